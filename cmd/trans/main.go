@@ -47,20 +47,67 @@ func main() { // nolint funlen
 	var healthHandler handlers.HealthHandler
 
 	// transHandler
-	transFactory := infrastructure.NewTextProtocolTransFactory(conf.Trans, logger)
+	transFactory, err := infrastructure.NewTextProtocolTransFactory(
+		conf.Trans,
+		logger,
+		prometheus.NewTransCollector(),
+	)
+	if err != nil {
+		logger.Crit("Error setting up trans: %s", err)
+		os.Exit(2)
+	}
+	schemas, err := conf.ServiceConf.ParseSchemas()
+	if err != nil {
+		logger.Crit("Error setting up schemas: %s", err)
+		os.Exit(2)
+	}
 	transRepository := services.NewTransRepo(transFactory)
 	transLogger := loggers.MakeTransInteractorLogger(logger)
 	transInteractor := usecases.TransInteractor{
 		Repository: transRepository,
 		Logger:     transLogger,
+		Schemas:    schemas,
 	}
 
+	injections, err := conf.ServiceConf.ParseInjections()
+	if err != nil {
+		logger.Crit("Error setting up injections: %s", err)
+		os.Exit(2)
+	}
+	trustedProxies, err := conf.ServiceConf.ParseTrustedProxies()
+	if err != nil {
+		logger.Crit("Error setting up trusted proxies: %s", err)
+		os.Exit(2)
+	}
+	responsePolicies, err := conf.ServiceConf.ParseResponsePolicies()
+	if err != nil {
+		logger.Crit("Error setting up response policies: %s", err)
+		os.Exit(2)
+	}
 	transHandler := handlers.TransHandler{
-		Interactor: transInteractor,
+		Interactor:       transInteractor,
+		Injections:       injections,
+		TrustedProxies:   trustedProxies,
+		ResponsePolicies: responsePolicies,
+	}
+	routes, err := conf.ServiceConf.ParseRoutes(&transHandler)
+	if err != nil {
+		logger.Crit("Error setting up routes: %s", err)
+		os.Exit(2)
+	}
+	authenticator, err := infrastructure.NewAuthenticator(
+		conf.ServiceConf,
+		logger,
+		prometheus.NewClientCollector(),
+	)
+	if err != nil {
+		logger.Crit("Error setting up clients: %s", err)
+		os.Exit(2)
 	}
 	// Setting up router
 	maker := infrastructure.RouterMaker{
 		Logger: logger,
+		Auth:   authenticator.Authenticate,
 		WrapperFuncs: []infrastructure.WrapperFunc{
 			prometheus.TrackHandlerFunc,
 		},
@@ -69,12 +116,13 @@ func main() { // nolint funlen
 			{
 				// This is the base path, all routes will start with this prefix
 				Prefix: "/api/v{version:[1-9][0-9]*}",
-				Groups: []infrastructure.Route{
+				Groups: append([]infrastructure.Route{
 					{
 						Name:    "Check service health",
 						Method:  "GET",
 						Pattern: "/healthcheck",
 						Handler: &healthHandler,
+						Public:  true,
 					},
 					{
 						Name:    "Execute a trans request",
@@ -82,7 +130,8 @@ func main() { // nolint funlen
 						Pattern: "/execute/{command}",
 						Handler: &transHandler,
 					},
-				},
+					// REST-style routes, declared on the configuration
+				}, routes...),
 			},
 		},
 	}
@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParamError describes why a param of a TransCommand was rejected
+type ParamError struct {
+	// Param the key of the rejected param
+	Param string
+	// Reason why the param was rejected
+	Reason string
+}
+
+// ParamsError is returned when one or more params of a TransCommand can not be sent to trans
+type ParamsError []ParamError
+
+// Error returns the rejected params along with their reasons
+func (e ParamsError) Error() string {
+	reasons := make([]string, 0, len(e))
+	for _, paramErr := range e {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", paramErr.Param, paramErr.Reason))
+	}
+	return fmt.Sprintf("invalid params - %s", strings.Join(reasons, ", "))
+}
+
+// BusyError is returned when trans refuses to execute a command because it is
+// overloaded. The command was never executed, so it can be safely tried again
+type BusyError struct {
+	// RetryAfter how long the caller should wait before trying again
+	RetryAfter time.Duration
+}
+
+// Error returns a description of the error
+func (e BusyError) Error() string {
+	return "trans is busy"
+}
+
+// ErrorClass groups the errors of a command by their cause, so callers can
+// react to them without knowing every status trans may return
+type ErrorClass string
+
+const (
+	// ErrorClassBadRequest the command or its params can't be executed as they are
+	ErrorClassBadRequest ErrorClass = "bad_request"
+	// ErrorClassNotFound the command, or what it refers to, doesn't exist
+	ErrorClassNotFound ErrorClass = "not_found"
+	// ErrorClassConflict the command clashes with the current state, like a duplicated entry
+	ErrorClassConflict ErrorClass = "conflict"
+	// ErrorClassValidation trans rejected one or more params of the command
+	ErrorClassValidation ErrorClass = "validation"
+	// ErrorClassUpstream trans failed executing the command, or couldn't be reached
+	ErrorClassUpstream ErrorClass = "upstream"
+	// ErrorClassTimeout trans didn't answer in time
+	ErrorClassTimeout ErrorClass = "timeout"
+	// ErrorClassUnavailable trans is known to be failing, so the command wasn't sent
+	ErrorClassUnavailable ErrorClass = "unavailable"
+	// ErrorClassOverloaded too many commands are waiting for trans, so the command wasn't sent
+	ErrorClassOverloaded ErrorClass = "overloaded"
+)
+
+// FieldError is an error reported by trans on a single param, like email:ERROR_EMAIL_INVALID
+type FieldError struct {
+	// Param the key of the param
+	Param string
+	// Code the error code returned by trans for the param
+	Code string
+}
+
+// TransError is returned when a command fails, either because trans answered
+// with an error status or field errors, or because it couldn't be executed at all
+type TransError struct {
+	// Class the kind of error
+	Class ErrorClass
+	// Code the trans status code, like TRANS_DATABASE_ERROR
+	Code string
+	// Message a description of the error, if any
+	Message string
+	// Fields the errors reported on each param, if any
+	Fields []FieldError
+}
+
+// Error returns the error message along with the field errors
+func (e TransError) Error() string {
+	message := e.Message
+	if message == "" {
+		message = e.Code
+	}
+	if len(e.Fields) == 0 {
+		return message
+	}
+	fields := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		fields = append(fields, fmt.Sprintf("%s: %s", field.Param, field.Code))
+	}
+	return fmt.Sprintf("%s - %s", message, strings.Join(fields, ", "))
+}
@@ -0,0 +1,41 @@
+package domain
+
+import "regexp"
+
+// ParamType is the type of the values a param takes
+type ParamType string
+
+const (
+	// ParamTypeAny any scalar value
+	ParamTypeAny ParamType = ""
+	// ParamTypeString text values
+	ParamTypeString ParamType = "string"
+	// ParamTypeInteger whole numbers
+	ParamTypeInteger ParamType = "integer"
+	// ParamTypeNumber any number
+	ParamTypeNumber ParamType = "number"
+	// ParamTypeBoolean true or false
+	ParamTypeBoolean ParamType = "boolean"
+)
+
+// ParamSchema declares the values a param of a command takes
+type ParamSchema struct {
+	Type ParamType
+	// Required if the param must be sent
+	Required bool
+	// Pattern the values must fully match it, if set
+	Pattern *regexp.Regexp
+	// MaxLength the maximum number of characters of the values. 0 means no limit
+	MaxLength int
+	// Enum the only values allowed, if any
+	Enum []string
+	// Blob if the param is sent as a blob
+	Blob bool
+}
+
+// CommandSchema declares the params of a command
+type CommandSchema struct {
+	Params map[string]ParamSchema
+	// Strict rejects the params that are not declared
+	Strict bool
+}
@@ -1,5 +1,7 @@
 package domain
 
+import "context"
+
 // TransParams is a struct with Trans format params
 type TransParams struct {
 	Key   string
@@ -7,24 +9,84 @@ type TransParams struct {
 	Blob  bool
 }
 
+// Priority is the class of traffic a command belongs to. Interactive
+// commands are scheduled before batch ones, that are shed first under pressure
+type Priority string
+
+const (
+	// PriorityInteractive commands someone is waiting for. It's the default
+	PriorityInteractive Priority = "interactive"
+	// PriorityBatch commands sent by background jobs
+	PriorityBatch Priority = "batch"
+)
+
+// ParsePriority returns the priority named s, if it's a known one
+func ParsePriority(s string) (Priority, bool) {
+	switch p := Priority(s); p {
+	case PriorityInteractive, PriorityBatch:
+		return p, true
+	}
+	return "", false
+}
+
 // TransCommand represents a trans command with params to be executed on a trans server
 type TransCommand struct {
 	// the command to be executed
 	Command string
 	// Params the params of the command
 	Params []TransParams
+	// DryRun if set, the command is validated by trans but its changes are not committed
+	DryRun bool
+	// Priority the class of traffic of the command. Empty means PriorityInteractive
+	Priority Priority
+}
+
+// TransField is a single key-value pair returned by a trans server
+type TransField struct {
+	Key string
+	// Value the value of the field. For blob fields it holds the raw bytes sent by trans
+	Value string
+	Blob  bool
 }
 
 // TransResponse represents the response given to the execution of a TransCommand
 type TransResponse struct {
 	// Status the status of the response (normally TRANS_OK or TRANS_ERROR)
 	Status string
-	// Params additional params returned
+	// Params additional params returned. If a key is repeated, only its last value is kept
 	Params map[string]string
+	// Fields every param returned, including repeated keys, in the order they were received
+	Fields []TransField
+}
+
+// Add appends a new param to the response, keeping Params and Fields in sync
+func (r *TransResponse) Add(key, value string) {
+	r.AddField(TransField{Key: key, Value: value})
+}
+
+// AddField appends a new field to the response, keeping Params and Fields in sync
+func (r *TransResponse) AddField(field TransField) {
+	if r.Params == nil {
+		r.Params = make(map[string]string)
+	}
+	r.Params[field.Key] = field.Value
+	r.Fields = append(r.Fields, field)
+}
+
+// Values returns every value returned for the given key, in the order they were received
+func (r TransResponse) Values(key string) []string {
+	var values []string
+	for _, field := range r.Fields {
+		if field.Key == key {
+			values = append(values, field.Value)
+		}
+	}
+	return values
 }
 
 // TransRepository defines a storage for the trans commands
 type TransRepository interface {
-	// Execute executes the command on a trans server
-	Execute(command TransCommand) (TransResponse, error)
+	// Execute executes the command on a trans server. The command is abandoned
+	// once ctx is done
+	Execute(ctx context.Context, command TransCommand) (TransResponse, error)
 }
@@ -3,6 +3,7 @@ package infrastructure
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -43,6 +44,9 @@ func (srv *MockTransServer) Start() {
 		panic("trans test server already started")
 	}
 	srv.Address = srv.listener.Addr().String()
+	if srv.listener.Addr().Network() == "unix" {
+		srv.Address = "unix://" + srv.Address
+	}
 	go func() {
 		_ = srv.Serve(srv.listener) // nolint: gosec
 	}()
@@ -73,16 +77,14 @@ func (srv *MockTransServer) handleRequest(conn io.ReadWriteCloser) {
 	busy := srv.IsBusy
 	srv.mtx.RUnlock()
 
+	// write errors mean the client went away, or failed the TLS handshake
 	if busy {
-		_, err := conn.Write([]byte(BusyMessage))
-		if err != nil {
-			panic(err)
-		}
+		_, _ = conn.Write([]byte(BusyMessage)) // nolint: gosec
 		return
 	}
 	_, err := conn.Write([]byte(WelcomeMessage))
 	if err != nil {
-		panic(err)
+		return
 	}
 
 	br := bufio.NewReader(conn)
@@ -92,7 +94,7 @@ func (srv *MockTransServer) handleRequest(conn io.ReadWriteCloser) {
 		buf, err := br.ReadBytes('\n') // nolint: vetshadow
 		if err != nil {
 			if err != io.EOF {
-				panic(err)
+				return
 			}
 			break
 		}
@@ -109,18 +111,15 @@ func (srv *MockTransServer) handleRequest(conn io.ReadWriteCloser) {
 	h := srv.handler
 	srv.mtx.RUnlock()
 
+	// the client may have stopped waiting for the response, so write errors are ignored
 	if h != nil {
 		res := h(args)
-		_, err = conn.Write(res)
-		if err != nil {
-			panic(err)
+		if _, err = conn.Write(res); err != nil {
+			return
 		}
 	}
 	// add the end of the message
-	_, err = conn.Write([]byte(EndMessage))
-	if err != nil {
-		panic(err)
-	}
+	_, _ = conn.Write([]byte(EndMessage)) // nolint: gosec
 }
 
 // SetHandler sets handler function.
@@ -147,6 +146,32 @@ func NewMockTransServer() *MockTransServer {
 	return s
 }
 
+// NewMockTLSTransServer starts and returns a new Server that only accepts
+// TLS connections, set up with config. The caller should call Close when
+// finished, to shut it down.
+func NewMockTLSTransServer(config *tls.Config) *MockTransServer {
+	s := &MockTransServer{
+		listener: tls.NewListener(newLocalListener(), config),
+	}
+	s.Start()
+	return s
+}
+
+// NewMockUnixTransServer starts and returns a new Server listening on the
+// unix socket at path. Its Address is the unix:// endpoint of the socket.
+// The caller should call Close when finished, to shut it down.
+func NewMockUnixTransServer(path string) *MockTransServer {
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		panic(fmt.Sprintf("transtest: failed to listen on %s: %v", path, err))
+	}
+	s := &MockTransServer{
+		listener: l,
+	}
+	s.Start()
+	return s
+}
+
 // newLocalListener starts a new TCP listener on the next available port
 func newLocalListener() net.Listener {
 	l, err := net.Listen("tcp", "127.0.0.1:0")
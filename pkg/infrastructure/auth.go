@@ -0,0 +1,394 @@
+package infrastructure
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Yapo/goutils"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/loggers"
+	"gopkg.in/gorilla/mux.v1"
+)
+
+// Client is a caller of the service, authenticated either by its API key on
+// the X-Api-Key header, or by requests signed with its secret
+type Client struct {
+	Name   string `json:"name"`
+	Key    string `json:"key"`
+	Secret string `json:"secret"`
+	// Commands the commands the client can execute. "*" allows every command
+	Commands []string `json:"commands"`
+	// Params restricts the params of each command: the values of a param
+	// must fully match its regular expression
+	Params map[string]map[string]string `json:"params"`
+	// Priority the class of traffic of the client: interactive or batch. It
+	// takes precedence over the priority requested by the client
+	Priority string `json:"priority"`
+}
+
+// authClient is a client ready to be checked
+type authClient struct {
+	name     string
+	secret   []byte
+	commands map[string]bool
+	params   map[string]map[string]*regexp.Regexp
+	priority domain.Priority
+}
+
+// signedHeaders the headers that change how a command is executed, so they
+// are covered by signatures along with the headers read by injected params
+var signedHeaders = []string{"X-Nonce", "X-Dry-Run", "X-Priority"} // nolint: gochecknoglobals
+
+// Authenticator is a middleware that only lets known clients through, to
+// the commands each one is allowed to execute. A nil Authenticator lets everyone through
+type Authenticator struct {
+	keys  map[string]*authClient
+	names map[string]*authClient
+	// commands the commands named by any client, the only ones counted by
+	// name on the metrics, so callers can't create labels at will
+	commands map[string]bool
+	maxAge   time.Duration
+	signed   []string
+	logger   loggers.Logger
+	metrics  *ClientCollector
+
+	// nonces the nonces of the signed requests, by client, until their
+	// timestamps expire, so requests can't be replayed
+	mutex  sync.Mutex
+	nonces map[string]time.Time
+	pruned time.Time
+}
+
+// NewAuthenticator creates an Authenticator for the clients on conf, written
+// as a JSON list of Client. If there are none, nil is returned
+func NewAuthenticator(conf ServiceConf, logger loggers.Logger, metrics *ClientCollector) (*Authenticator, error) {
+	if conf.Clients == "" {
+		return nil, nil
+	}
+	var clients []Client
+	if err := json.Unmarshal([]byte(conf.Clients), &clients); err != nil {
+		return nil, fmt.Errorf("invalid clients: %s", err)
+	}
+	signed, err := conf.signedHeaders()
+	if err != nil {
+		return nil, err
+	}
+	a := &Authenticator{
+		keys:     make(map[string]*authClient),
+		names:    make(map[string]*authClient),
+		commands: make(map[string]bool),
+		maxAge:   conf.SignatureMaxAge,
+		signed:   signed,
+		logger:   logger,
+		metrics:  metrics,
+		nonces:   make(map[string]time.Time),
+	}
+	for _, client := range clients {
+		c, err := newAuthClient(client)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := a.names[c.name]; ok {
+			return nil, fmt.Errorf("client %s is repeated", c.name)
+		}
+		a.names[c.name] = c
+		for cmd := range c.commands {
+			if cmd != "*" {
+				a.commands[cmd] = true
+			}
+		}
+		if client.Key != "" {
+			if _, ok := a.keys[client.Key]; ok {
+				return nil, fmt.Errorf("the key of client %s is repeated", c.name)
+			}
+			a.keys[client.Key] = c
+		}
+	}
+	return a, nil
+}
+
+// signedHeaders lists the headers covered by signatures: signedHeaders and the
+// headers read by injected params
+func (conf ServiceConf) signedHeaders() ([]string, error) {
+	injections, err := conf.ParseInjections()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for _, name := range signedHeaders {
+		names[name] = true
+	}
+	for _, list := range injections {
+		for _, injection := range list {
+			if injection.Source == handlers.InjectHeader {
+				names[http.CanonicalHeaderKey(injection.Header)] = true
+			}
+		}
+	}
+	signed := make([]string, 0, len(names))
+	for name := range names {
+		signed = append(signed, name)
+	}
+	sort.Strings(signed)
+	return signed, nil
+}
+
+// newAuthClient checks the client and compiles its param restrictions
+func newAuthClient(client Client) (*authClient, error) {
+	if client.Name == "" {
+		return nil, fmt.Errorf("clients must have a name")
+	}
+	if client.Key == "" && client.Secret == "" {
+		return nil, fmt.Errorf("client %s has neither key nor secret", client.Name)
+	}
+	c := &authClient{
+		name:     client.Name,
+		secret:   []byte(client.Secret),
+		commands: make(map[string]bool),
+		params:   make(map[string]map[string]*regexp.Regexp),
+	}
+	if client.Priority != "" {
+		priority, ok := domain.ParsePriority(client.Priority)
+		if !ok {
+			return nil, fmt.Errorf("client %s has an unknown priority %s", client.Name, client.Priority)
+		}
+		c.priority = priority
+	}
+	for _, cmd := range client.Commands {
+		c.commands[cmd] = true
+	}
+	for cmd, params := range client.Params {
+		c.params[cmd] = make(map[string]*regexp.Regexp)
+		for param, pattern := range params {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("client %s: invalid pattern for %s on %s: %s", client.Name, param, cmd, err)
+			}
+			c.params[cmd][param] = re
+		}
+	}
+	return c, nil
+}
+
+// Authenticate is a WrapperFunc that answers 401 Unauthorized to unknown
+// clients and 403 Forbidden to clients executing commands or params they are
+// not allowed to. Every request is logged and counted under its client, and
+// the name and the priority of the client are carried by the context of the request. Requests
+// to REST-style routes are checked against the command and params they were rewritten into
+func (a *Authenticator) Authenticate(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	if a == nil {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		command := mux.Vars(r)["command"]
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeAuthError(w, http.StatusBadRequest, "the request body can't be read")
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		// requests to REST-style routes were signed before being rewritten
+		signed := body
+		if routed, ok := handlers.RoutedRequestFrom(r.Context()); ok {
+			command, signed = routed.Command, routed.Body
+		}
+
+		client, err := a.identify(r, signed)
+		if err != nil {
+			a.logger.Info("Unauthenticated request to %s: %s\n", r.URL.Path, err)
+			a.metrics.CollectClientRequest("", "", "unauthenticated")
+			writeAuthError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err := client.authorize(command, body); err != nil {
+			a.logger.Info("Client %s denied on %s: %s\n", client.name, r.URL.Path, err)
+			a.metrics.CollectClientRequest(client.name, a.label(command), "denied")
+			writeAuthError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		a.logger.Debug("Client %s requested %s\n", client.name, r.URL.Path)
+		a.metrics.CollectClientRequest(client.name, a.label(command), "allowed")
+		ctx := handlers.WithClient(r.Context(), client.name)
+		if client.priority != "" {
+			ctx = handlers.WithPriority(ctx, client.priority)
+		}
+		handler(w, r.WithContext(ctx))
+	}
+}
+
+// label returns the command as a metric label. Commands no client names are
+// counted under ""
+func (a *Authenticator) label(command string) string {
+	if !a.commands[command] {
+		return ""
+	}
+	return command
+}
+
+// identify finds the client of the request, by its API key or its signature
+func (a *Authenticator) identify(r *http.Request, body []byte) (*authClient, error) {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		client, ok := a.keys[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown API key")
+		}
+		return client, nil
+	}
+	name := r.Header.Get("X-Client")
+	signature := r.Header.Get("X-Signature")
+	if name == "" || signature == "" {
+		return nil, fmt.Errorf("missing credentials")
+	}
+	client, ok := a.names[name]
+	if !ok || len(client.secret) == 0 {
+		return nil, fmt.Errorf("unknown client %s", name)
+	}
+	timestamp := r.Header.Get("X-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(seconds, 0)); age > a.maxAge || age < -a.maxAge {
+		return nil, fmt.Errorf("expired signature")
+	}
+	nonce := r.Header.Get("X-Nonce")
+	if nonce == "" {
+		return nil, fmt.Errorf("missing nonce")
+	}
+	headers := make(http.Header)
+	for _, name := range a.signed {
+		if values, ok := r.Header[name]; ok {
+			headers[name] = values
+		}
+	}
+	expected := Sign(client.secret, timestamp, r.Method, r.URL.RequestURI(), headers, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	if !a.useNonce(client.name, nonce, time.Unix(seconds, 0).Add(a.maxAge)) {
+		return nil, fmt.Errorf("replayed request")
+	}
+	return client, nil
+}
+
+// useNonce remembers the nonce of the client until expires. It returns false
+// if the nonce was already used
+func (a *Authenticator) useNonce(client, nonce string, expires time.Time) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(a.pruned) > a.maxAge {
+		for key, expiry := range a.nonces {
+			if now.After(expiry) {
+				delete(a.nonces, key)
+			}
+		}
+		a.pruned = now
+	}
+	key := client + "\n" + nonce
+	if _, ok := a.nonces[key]; ok {
+		return false
+	}
+	a.nonces[key] = expires
+	return true
+}
+
+// Sign returns the signature of a request, the hex encoded HMAC-SHA256 of its
+// timestamp, method and URI, its signed headers, as lowercase name:value sorted
+// by name, each one followed by a newline, and its body
+func Sign(secret []byte, timestamp, method, uri string, headers http.Header, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n", timestamp, method, uri)
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(mac, "%s:%s\n", strings.ToLower(name), strings.Join(headers[name], ","))
+	}
+	mac.Write(body) // nolint: errcheck, gosec
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// authorize checks the client can execute the command with the params on body
+func (c *authClient) authorize(command string, body []byte) error {
+	if command == "" {
+		return nil
+	}
+	if !c.commands[command] && !c.commands["*"] {
+		return fmt.Errorf("command %s is not allowed", command)
+	}
+	restricted := c.params[command]
+	if len(restricted) == 0 {
+		return nil
+	}
+	var input struct {
+		Params map[string]interface{} `json:"params"`
+	}
+	if err := json.Unmarshal(body, &input); err != nil {
+		return fmt.Errorf("the params can't be checked: %s", err)
+	}
+	for _, param := range flattenParams(input.Params) {
+		re, ok := restricted[param.key]
+		if !ok {
+			continue
+		}
+		if param.value == nil || !re.MatchString(fmt.Sprint(param.value)) {
+			return fmt.Errorf("param %s is not allowed", param.key)
+		}
+	}
+	return nil
+}
+
+// authParam is a single param of a request
+type authParam struct {
+	key   string
+	value interface{}
+}
+
+// flattenParams lists the params the way the trans handler reads them: lists
+// are repeated params, and lists of objects, like blobs, hold their own keys
+func flattenParams(params map[string]interface{}) []authParam {
+	var flat []authParam
+	for key, value := range params {
+		values, ok := value.([]interface{})
+		if !ok {
+			flat = append(flat, authParam{key: key, value: value})
+			continue
+		}
+		for _, val := range values {
+			if object, ok := val.(map[string]interface{}); ok {
+				for k, v := range object {
+					flat = append(flat, authParam{key: k, value: v})
+				}
+			} else {
+				flat = append(flat, authParam{key: key, value: val})
+			}
+		}
+	}
+	return flat
+}
+
+// writeAuthError answers the request with the given code and message
+func writeAuthError(w http.ResponseWriter, code int, message string) {
+	response := &goutils.Response{
+		Code: code,
+		Body: goutils.GenericError{ErrorMessage: message},
+	}
+	goutils.CreateJSON(response)
+	goutils.WriteJSONResponse(w, response)
+}
@@ -0,0 +1,246 @@
+package infrastructure
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/loggers"
+	"gopkg.in/gorilla/mux.v1"
+)
+
+const testClients = `[
+	{"name": "web", "key": "web-key", "commands": ["transinfo", "get_account"],
+	 "params": {"get_account": {"account_id": "[0-9]+"}}},
+	{"name": "backoffice", "secret": "s3cr3t", "commands": ["*"]},
+	{"name": "nightly", "key": "nightly-key", "commands": ["transinfo"], "priority": "batch"}
+]`
+
+// newAuthRouter routes /execute/{command} through the authenticator to a
+// handler that echoes the request body
+func newAuthRouter(t *testing.T, logger *MockLoggerInfrastructure) http.Handler {
+	authenticator, err := NewAuthenticator(
+		ServiceConf{Clients: testClients, SignatureMaxAge: time.Minute},
+		logger,
+		nil,
+	)
+	assert.NoError(t, err)
+	router := mux.NewRouter()
+	router.HandleFunc("/execute/{command}", authenticator.Authenticate("/execute/{command}",
+		func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("X-Client", handlers.ClientFrom(r.Context()))
+			w.Header().Set("X-Priority", string(handlers.PriorityFrom(r.Context())))
+			w.Write(body) // nolint: errcheck, gosec
+		}))
+	return router
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	authenticator, err := NewAuthenticator(ServiceConf{}, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, authenticator)
+	handler := func(w http.ResponseWriter, r *http.Request) {}
+	assert.NotNil(t, authenticator.Authenticate("/", handler))
+
+	for name, clients := range map[string]string{
+		"json":     `{"name": "web"}`,
+		"name":     `[{"key": "k"}]`,
+		"creds":    `[{"name": "web"}]`,
+		"repeated": `[{"name": "web", "key": "a"}, {"name": "web", "key": "b"}]`,
+		"key":      `[{"name": "web", "key": "a"}, {"name": "app", "key": "a"}]`,
+		"pattern":  `[{"name": "web", "key": "a", "params": {"newad": {"id": "("}}}]`,
+		"priority": `[{"name": "web", "key": "a", "priority": "urgent"}]`,
+	} {
+		_, err := NewAuthenticator(ServiceConf{Clients: clients}, nil, nil)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestAuthenticatorLabel(t *testing.T) {
+	authenticator, err := NewAuthenticator(ServiceConf{Clients: testClients}, nil, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "get_account", authenticator.label("get_account"))
+	// commands no client names are counted together, even if some client has "*"
+	assert.Equal(t, "", authenticator.label("newad"))
+	assert.Equal(t, "", authenticator.label("*"))
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	logger.On("Info")
+	router := newAuthRouter(t, &logger)
+
+	cases := []struct {
+		key, command, body string
+		code               int
+	}{
+		{"web-key", "transinfo", `{}`, http.StatusOK},
+		{"web-key", "get_account", `{"params": {"account_id": 12}}`, http.StatusOK},
+		{"web-key", "get_account", `{"params": {"account_id": "12; drop"}}`, http.StatusForbidden},
+		{"web-key", "get_account", `{"params": {"account_id": [1, "x"]}}`, http.StatusForbidden},
+		{"web-key", "get_account", `not json`, http.StatusForbidden},
+		{"web-key", "newad", `{}`, http.StatusForbidden},
+		{"other-key", "transinfo", `{}`, http.StatusUnauthorized},
+		{"", "transinfo", `{}`, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/execute/"+c.command, strings.NewReader(c.body))
+		r.Header.Set("X-Api-Key", c.key)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, c.code, w.Code, "%s %s %s", c.key, c.command, c.body)
+		if c.code == http.StatusOK {
+			// the handler still gets the body, and the client
+			assert.Equal(t, c.body, w.Body.String())
+			assert.Equal(t, "web", w.Header().Get("X-Client"))
+		}
+	}
+}
+
+func TestAuthenticatePriority(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	router := newAuthRouter(t, &logger)
+
+	// the priority of the client is carried by the context, whatever it requested
+	cases := map[string]string{"nightly-key": "batch", "web-key": ""}
+	for key, priority := range cases {
+		r := httptest.NewRequest("POST", "/execute/transinfo", strings.NewReader(`{}`))
+		r.Header.Set("X-Api-Key", key)
+		r.Header.Set("X-Priority", "interactive")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, key)
+		assert.Equal(t, priority, w.Header().Get("X-Priority"), key)
+	}
+}
+
+// commandRecorder is an interactor that records the commands it executes
+type commandRecorder struct {
+	commands []string
+}
+
+func (r *commandRecorder) ExecuteCommand(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
+	r.commands = append(r.commands, command.Command)
+	return domain.TransResponse{Status: "TRANS_OK"}, nil
+}
+
+func TestAuthenticateCommandFromRoute(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	logger.On("Info")
+	authenticator, err := NewAuthenticator(ServiceConf{Clients: testClients}, &logger, nil)
+	assert.NoError(t, err)
+	recorder := &commandRecorder{}
+	handler := handlers.MakeJSONHandlerFunc(
+		&handlers.TransHandler{Interactor: recorder},
+		loggers.MakeJSONHandlerLogger(&logger),
+	)
+	router := mux.NewRouter()
+	router.HandleFunc("/execute/{command}", authenticator.Authenticate("/execute/{command}", handler))
+
+	// web may only execute transinfo, so the body can't turn it into newad
+	r := httptest.NewRequest("POST", "/execute/transinfo", strings.NewReader(`{"command": "newad"}`))
+	r.Header.Set("X-Api-Key", "web-key")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"transinfo"}, recorder.commands)
+}
+
+func TestAuthenticateSignature(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	logger.On("Info")
+	router := newAuthRouter(t, &logger)
+	body := `{"params": {"ad_id": 1}}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	sign := func(secret, timestamp, uri, nonce string) string {
+		return Sign([]byte(secret), timestamp, "POST", uri, http.Header{"X-Nonce": {nonce}}, []byte(body))
+	}
+
+	cases := []struct {
+		client, timestamp, nonce, signature string
+		code                                int
+	}{
+		{"backoffice", now, "1", sign("s3cr3t", now, "/execute/newad", "1"), http.StatusOK},
+		{"backoffice", now, "2", sign("wrong", now, "/execute/newad", "2"), http.StatusUnauthorized},
+		{"backoffice", now, "3", sign("s3cr3t", now, "/execute/clear", "3"), http.StatusUnauthorized},
+		{"backoffice", old, "4", sign("s3cr3t", old, "/execute/newad", "4"), http.StatusUnauthorized},
+		{"backoffice", "", "5", sign("s3cr3t", "", "/execute/newad", "5"), http.StatusUnauthorized},
+		{"web", now, "6", sign("", now, "/execute/newad", "6"), http.StatusUnauthorized},
+		{"backoffice", now, "7", "", http.StatusUnauthorized},
+		// the nonce is signed, and it's required
+		{"backoffice", now, "8", sign("s3cr3t", now, "/execute/newad", "9"), http.StatusUnauthorized},
+		{"backoffice", now, "", sign("s3cr3t", now, "/execute/newad", ""), http.StatusUnauthorized},
+	}
+	for i, c := range cases {
+		r := httptest.NewRequest("POST", "/execute/newad", strings.NewReader(body))
+		r.Header.Set("X-Client", c.client)
+		r.Header.Set("X-Timestamp", c.timestamp)
+		r.Header.Set("X-Nonce", c.nonce)
+		r.Header.Set("X-Signature", c.signature)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, c.code, w.Code, "case %d", i)
+	}
+}
+
+func TestAuthenticateSignatureHeaders(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	logger.On("Info")
+	router := newAuthRouter(t, &logger)
+	body := `{"params": {"ad_id": 1}}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	headers := http.Header{"X-Nonce": {"1"}, "X-Dry-Run": {"1"}}
+	signature := Sign([]byte("s3cr3t"), now, "POST", "/execute/newad", headers, []byte(body))
+	request := func(dryRun string) *http.Request {
+		r := httptest.NewRequest("POST", "/execute/newad", strings.NewReader(body))
+		r.Header.Set("X-Client", "backoffice")
+		r.Header.Set("X-Timestamp", now)
+		r.Header.Set("X-Nonce", "1")
+		r.Header.Set("X-Signature", signature)
+		if dryRun != "" {
+			r.Header.Set("X-Dry-Run", dryRun)
+		}
+		return r
+	}
+
+	// signed headers can't be stripped, added or changed
+	for _, dryRun := range []string{"", "0"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, request(dryRun))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, dryRun)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, request("1"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// and the same request can't be replayed
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, request("1"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestSignedHeaders(t *testing.T) {
+	signed, err := ServiceConf{Injections: `{
+		"newad": [{"param": "source", "from": "header", "header": "x-source"}]
+	}`}.signedHeaders()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"X-Dry-Run", "X-Nonce", "X-Priority", "X-Source"}, signed)
+
+	_, err = NewAuthenticator(ServiceConf{Clients: testClients, Injections: `[]`}, nil, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package infrastructure
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// transCharset is the character set used on the wire to talk to trans
+type transCharset struct {
+	// name the canonical name of the charset
+	name string
+	// charmap the single byte charset to use. UTF-8 has none, as the text is
+	// sent to trans as it is
+	charmap *charmap.Charmap
+}
+
+// newTransCharset returns the transCharset with the given name. Supported
+// charsets are UTF-8, ISO-8859-1 (Latin 1) and Windows-1252. If no name is
+// given, ISO-8859-1 is used
+func newTransCharset(name string) (transCharset, error) {
+	switch strings.ToUpper(strings.Replace(name, "_", "-", -1)) {
+	case "UTF-8", "UTF8":
+		return transCharset{name: "UTF-8"}, nil
+	case "", "ISO-8859-1", "ISO8859-1", "LATIN1", "LATIN-1":
+		return transCharset{name: "ISO-8859-1", charmap: charmap.ISO8859_1}, nil
+	case "WINDOWS-1252", "CP1252":
+		return transCharset{name: "Windows-1252", charmap: charmap.Windows1252}, nil
+	}
+	return transCharset{}, fmt.Errorf("unsupported trans charset %q", name)
+}
+
+// canEncode tells whether the rune can be represented on the charset
+func (c transCharset) canEncode(r rune) bool {
+	if c.charmap == nil {
+		return r != utf8.RuneError
+	}
+	_, ok := c.charmap.EncodeRune(r)
+	return ok
+}
+
+// encode converts the UTF-8 string s to the charset
+func (c transCharset) encode(s string) (string, error) {
+	if c.charmap == nil {
+		if !utf8.ValidString(s) {
+			return "", fmt.Errorf("invalid UTF-8 text")
+		}
+		return s, nil
+	}
+	return c.charmap.NewEncoder().String(s)
+}
+
+// decode converts the string s, encoded on the charset, to UTF-8.
+// Bytes that have no meaning on the charset are reported as an error
+func (c transCharset) decode(s string) (string, error) {
+	if c.charmap == nil {
+		if !utf8.ValidString(s) {
+			return "", fmt.Errorf("invalid UTF-8 text %q", s)
+		}
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		r := c.charmap.DecodeByte(s[i])
+		if r == utf8.RuneError {
+			return "", fmt.Errorf("byte %#x is not valid %s", s[i], c.name)
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,61 @@
+package infrastructure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTransCharset(t *testing.T) {
+	cases := map[string]string{
+		"":             "ISO-8859-1",
+		"latin1":       "ISO-8859-1",
+		"iso-8859-1":   "ISO-8859-1",
+		"utf8":         "UTF-8",
+		"UTF-8":        "UTF-8",
+		"windows-1252": "Windows-1252",
+		"CP1252":       "Windows-1252",
+	}
+	for name, expected := range cases {
+		charset, err := newTransCharset(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, charset.name)
+	}
+	_, err := newTransCharset("EBCDIC")
+	assert.Error(t, err)
+}
+
+func TestTransCharsetEncode(t *testing.T) {
+	utf8Charset, _ := newTransCharset("UTF-8")
+	windows1252, _ := newTransCharset("Windows-1252")
+
+	encoded, err := utf8Charset.encode("“Auto” 🚗")
+	assert.NoError(t, err)
+	assert.Equal(t, "“Auto” 🚗", encoded)
+
+	encoded, err = windows1252.encode("“Auto” €")
+	assert.NoError(t, err)
+	assert.Equal(t, "\x93Auto\x94 \x80", encoded)
+
+	_, err = windows1252.encode("🚗")
+	assert.Error(t, err)
+}
+
+func TestTransCharsetDecode(t *testing.T) {
+	utf8Charset, _ := newTransCharset("UTF-8")
+	windows1252, _ := newTransCharset("Windows-1252")
+
+	decoded, err := latin1.decode("ok\xc1")
+	assert.NoError(t, err)
+	assert.Equal(t, "okÁ", decoded)
+
+	decoded, err = windows1252.decode("\x93Auto\x94")
+	assert.NoError(t, err)
+	assert.Equal(t, "“Auto”", decoded)
+
+	_, err = windows1252.decode("bad\x81")
+	assert.EqualError(t, err, "byte 0x81 is not valid Windows-1252")
+
+	_, err = utf8Charset.decode("ok\xc1")
+	assert.Error(t, err)
+}
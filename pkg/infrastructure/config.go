@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // ServiceConf holds configuration for this Service
@@ -15,6 +16,28 @@ type ServiceConf struct {
 	Host string `env:"HOST" envDefault:":8080"`
 	// Profiling if the service should add profiling endpoints with net/http/pprof
 	Profiling bool `env:"PROFILING" envDefault:"true"`
+	// Clients the clients allowed to use the service, as a JSON list of
+	// Client, usually read from the file on SERVICE_CLIENTS_FILE. If empty,
+	// every caller is allowed. Being secrets, they are left out of the printed configuration
+	Clients string `env:"CLIENTS" envDefault:"" json:"-"`
+	// Schemas the params declared for each command, as JSON, usually read from
+	// the file on SERVICE_SCHEMAS_FILE. Commands without a schema aren't validated
+	Schemas string `env:"SCHEMAS" envDefault:"" json:"-"`
+	// Injections the params set on the server side for each command, as JSON,
+	// usually read from the file on SERVICE_INJECTIONS_FILE. Static values may
+	// be secrets, so they are left out of the printed configuration
+	Injections string `env:"INJECTIONS" envDefault:"" json:"-"`
+	// TrustedProxies is a list of networks or addresses, separated by '|', of
+	// the proxies whose X-Forwarded-For header is honoured to find the client IP
+	TrustedProxies string `env:"TRUSTED_PROXIES" envDefault:""`
+	// ResponsePolicies the keys of the response returned for each command, and
+	// how they are masked, as JSON, usually read from the file on SERVICE_RESPONSE_POLICIES_FILE
+	ResponsePolicies string `env:"RESPONSE_POLICIES" envDefault:""`
+	// Routes the REST-style routes mapped onto trans commands, as a JSON list,
+	// usually read from the file on SERVICE_ROUTES_FILE
+	Routes string `env:"ROUTES" envDefault:""`
+	// SignatureMaxAge how old the timestamp of a signed request can be
+	SignatureMaxAge time.Duration `env:"SIGNATURE_MAX_AGE" envDefault:"5m"`
 }
 
 // LoggerConf holds configuration for logging
@@ -48,14 +71,130 @@ type TransConf struct {
 	// AllowedCommands is a list with one or more trans commands, separated by '|'
 	// that indicates the allowed commands to be sent by this service
 	AllowedCommands string `env:"COMMANDS" envDefault:"transinfo"`
+	// DryRunCommands is a list of commands, separated by '|', that can be sent
+	// without committing them, so trans only validates their params
+	DryRunCommands string `env:"DRY_RUN_COMMANDS" envDefault:""`
 	// Host is the host of the trans Server
 	Host string `env:"HOST" envDefault:"localhost"`
 	// Port is the port of the trans server
 	Port int `env:"PORT" envDefault:"20005"`
-	// Timeout wait time before a request times out
+	// Endpoint the trans server, written as tcp://host:port or
+	// unix:///path/to/sock. If empty, the trans server on Host and Port is used
+	Endpoint string `env:"ENDPOINT" envDefault:""`
+	// Backends is a list of trans servers, separated by '|', written as
+	// endpoint[,weight[,priority]]. Lower priorities are used first. If empty,
+	// the trans server on Endpoint is used
+	Backends string `env:"BACKENDS" envDefault:""`
+	// Balancer how backends of the same priority are picked: round_robin or least_inflight
+	Balancer string `env:"BALANCER" envDefault:"round_robin"`
+	// EjectAfter consecutive connection failures or busy greetings before a
+	// backend is ejected. 0 never ejects backends
+	EjectAfter int `env:"EJECT_AFTER" envDefault:"3"`
+	// ProbeInterval the wait between transinfo probes of an ejected backend
+	ProbeInterval time.Duration `env:"PROBE_INTERVAL" envDefault:"5s"`
+	// BreakerErrors consecutive failures of a backend that open its circuit breaker. 0 disables it
+	BreakerErrors int `env:"BREAKER_ERRORS" envDefault:"5"`
+	// BreakerSuccesses consecutive successes of a backend that close its half open circuit breaker
+	BreakerSuccesses int `env:"BREAKER_SUCCESSES" envDefault:"2"`
+	// BreakerTimeout how long a circuit breaker stays open before half opening
+	BreakerTimeout time.Duration `env:"BREAKER_TIMEOUT" envDefault:"10s"`
+	// TLS connects to trans through TLS
+	TLS bool `env:"TLS" envDefault:"false"`
+	// TLSCAFile the CA bundle used to verify trans. If empty, the system CAs are used
+	TLSCAFile string `env:"TLS_CA_FILE" envDefault:""`
+	// TLSCertFile and TLSKeyFile the client certificate presented to trans, if any
+	TLSCertFile string `env:"TLS_CERT_FILE" envDefault:""`
+	TLSKeyFile  string `env:"TLS_KEY_FILE" envDefault:""`
+	// TLSServerName the name expected on the trans certificate. If empty, the backend host is used
+	TLSServerName string `env:"TLS_SERVER_NAME" envDefault:""`
+	// TLSMinVersion the oldest TLS version accepted: 1.0, 1.1, 1.2 or 1.3
+	TLSMinVersion string `env:"TLS_MIN_VERSION" envDefault:"1.2"`
+	// Timeout wait time in seconds before a request times out
 	Timeout int `env:"TIMEOUT" envDefault:"15"`
-	// RetryAfter wait time between reconnection to the trans server
-	RetryAfter int `env:"RETRY" envDefault:"5"`
+	// CommandTimeouts overrides Timeout for some commands. It's a list separated
+	// by '|' of command=duration, like transinfo=2s|imgput=60s
+	CommandTimeouts string `env:"COMMAND_TIMEOUTS" envDefault:""`
+	// DialTimeout how long connecting to a trans backend can take. 0 means up to the request deadline
+	DialTimeout time.Duration `env:"DIAL_TIMEOUT" envDefault:"5s"`
+	// GreetingTimeout how long trans can take to greet once connected. 0 means up to the request deadline
+	GreetingTimeout time.Duration `env:"GREETING_TIMEOUT" envDefault:"5s"`
+	// ReadTimeout how long trans can take to respond once the command is sent. 0 means up to the request deadline
+	ReadTimeout time.Duration `env:"READ_TIMEOUT" envDefault:"0s"`
+	// MaxConcurrency how many commands can be sent to trans at the same time. 0 means no limit
+	MaxConcurrency int `env:"MAX_CONCURRENCY" envDefault:"0"`
+	// CommandConcurrency limits how many of some commands can be sent at the
+	// same time. It's a list separated by '|' of command=limit, like imgput=2|newad=10
+	CommandConcurrency string `env:"COMMAND_CONCURRENCY" envDefault:""`
+	// QueueSize how many commands can wait for a free slot when the limits are reached
+	QueueSize int `env:"QUEUE_SIZE" envDefault:"100"`
+	// QueueTimeout how long a command can wait for a free slot
+	QueueTimeout time.Duration `env:"QUEUE_TIMEOUT" envDefault:"1s"`
+	// PriorityWeights how many waiting commands of each priority get a free
+	// slot in relation to the others. It's a list separated by '|' of priority=weight
+	PriorityWeights string `env:"PRIORITY_WEIGHTS" envDefault:"interactive=4|batch=1"`
+	// AdaptiveLimit enables adapting how many commands can be in flight to the latency of trans
+	AdaptiveLimit bool `env:"ADAPTIVE_LIMIT" envDefault:"false"`
+	// LimitInitial how many commands can be in flight before the limit adapts
+	LimitInitial int `env:"LIMIT_INITIAL" envDefault:"20"`
+	// LimitMin the adaptive limit never goes below LimitMin
+	LimitMin int `env:"LIMIT_MIN" envDefault:"1"`
+	// LimitMax the adaptive limit never goes above LimitMax. 0 means no maximum
+	LimitMax int `env:"LIMIT_MAX" envDefault:"200"`
+	// LimitLatency attempts slower than this lower the adaptive limit. It scales
+	// with the timeout of each command, relative to Timeout
+	LimitLatency time.Duration `env:"LIMIT_LATENCY" envDefault:"1s"`
+	// LimitBackoff percent the adaptive limit is multiplied by when trans is overloaded
+	LimitBackoff int `env:"LIMIT_BACKOFF" envDefault:"90"`
+	// LimitBatch percent of the adaptive limit batch commands can take. The
+	// rest is left for interactive commands, so batch commands are shed first
+	LimitBatch int `env:"LIMIT_BATCH" envDefault:"75"`
+	// HedgeCommands is a list of read-only commands, separated by '|', that are
+	// sent again to another backend when they are slow. Only commands that are
+	// safe to run twice at the same time must be listed, and they must also be on
+	// IdempotentCommands. If empty, nothing is hedged
+	HedgeCommands string `env:"HEDGE_COMMANDS" envDefault:""`
+	// HedgePercentile the percentile of the latest latencies of a command after
+	// which it is hedged
+	HedgePercentile int `env:"HEDGE_PERCENTILE" envDefault:"95"`
+	// HedgeMinDelay the minimum wait before a command is hedged
+	HedgeMinDelay time.Duration `env:"HEDGE_MIN_DELAY" envDefault:"10ms"`
+	// AttemptTimeout how long each attempt to send a command can take, so
+	// timed out commands can be retried within Timeout. 0 means no limit
+	AttemptTimeout time.Duration `env:"ATTEMPT_TIMEOUT" envDefault:"0s"`
+	// RetryAfter is no longer used: failed commands wait RetryBackoff before
+	// being retried. A warning is logged when it's set
+	RetryAfter int `env:"RETRY" envDefault:"0"`
+	// RetryAttempts how many times a failed command is sent again
+	RetryAttempts int `env:"RETRY_ATTEMPTS" envDefault:"2"`
+	// RetryBackoff wait time before the first retry. It doubles on each retry
+	RetryBackoff time.Duration `env:"RETRY_BACKOFF" envDefault:"200ms"`
+	// RetryMaxBackoff the maximum wait time between retries. 0 means no limit
+	RetryMaxBackoff time.Duration `env:"RETRY_MAX_BACKOFF" envDefault:"2s"`
+	// RetryJitter up to which percent of each wait time is taken away at random
+	RetryJitter int `env:"RETRY_JITTER" envDefault:"50"`
+	// IdempotentCommands is a list of commands, separated by '|', that can be
+	// safely executed more than once, so they are retried on timeouts and RetryStatuses
+	IdempotentCommands string `env:"IDEMPOTENT_COMMANDS" envDefault:"transinfo"`
+	// RetryStatuses is a list of trans statuses, separated by '|', that are
+	// transient failures. Idempotent commands returning a status starting with them are retried
+	RetryStatuses string `env:"RETRY_STATUSES" envDefault:"TRANS_DATABASE_ERROR"`
+	// BusyRetryAfter seconds a caller is told to wait before trying again when trans is busy
+	BusyRetryAfter int `env:"BUSY_RETRY_AFTER" envDefault:"1"`
+	// Charset the character set used to talk to trans: UTF-8, ISO-8859-1 or Windows-1252
+	Charset string `env:"CHARSET" envDefault:"ISO-8859-1"`
+	// NullAsEmpty sends null params as empty values. If false, null params are omitted
+	NullAsEmpty bool `env:"NULL_AS_EMPTY" envDefault:"false"`
+	// Strict rejects commands with params that can not be encoded, instead of leaving those params out
+	Strict bool `env:"STRICT" envDefault:"true"`
+	// Transliterate replaces characters that can not be encoded with an approximation
+	Transliterate bool `env:"TRANSLITERATE" envDefault:"false"`
+	// MaxResponseSize the maximum size in bytes of a trans response. 0 means no limit
+	MaxResponseSize int `env:"MAX_RESPONSE_SIZE" envDefault:"16777216"`
+	// MaxBlobSize the maximum size in bytes of a blob returned by trans. 0 means no limit
+	MaxBlobSize int `env:"MAX_BLOB_SIZE" envDefault:"8388608"`
+	// MultilineBlobCommands is a list of commands, separated by '|', whose multi-line
+	// values are sent as blobs. Other commands reject those values
+	MultilineBlobCommands string `env:"MULTILINE_BLOB_COMMANDS" envDefault:""`
 }
 
 // Config holds all configuration for the service
@@ -122,6 +261,13 @@ func load(conf reflect.Value, envTag, envDefault string) {
 				if value, err := strconv.ParseBool(value); err == nil {
 					reflectedConf.Set(reflect.ValueOf(value))
 				}
+			case reflect.Int64:
+				// durations are written like 1500ms or 2s
+				if reflectedConf.Type() == reflect.TypeOf(time.Duration(0)) {
+					if value, err := time.ParseDuration(value); err == nil {
+						reflectedConf.Set(reflect.ValueOf(value))
+					}
+				}
 			}
 		}
 	}
@@ -1,8 +1,10 @@
 package infrastructure
 
 import (
+	"encoding/json"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,12 +14,14 @@ type Nested struct {
 }
 
 type TestConf struct {
-	I  int    `env:"LE_I"`
-	S  string `env:"LE_S"`
-	F  string `env:"FROM"`
-	N  Nested `env:"NESTED_"`
-	D  string `env:"DEF" envDefault:"default_conf"`
-	OF string `env:"OTHERFILE"`
+	I  int           `env:"LE_I"`
+	S  string        `env:"LE_S"`
+	F  string        `env:"FROM"`
+	N  Nested        `env:"NESTED_"`
+	D  string        `env:"DEF" envDefault:"default_conf"`
+	OF string        `env:"OTHERFILE"`
+	T  time.Duration `env:"LE_T"`
+	DT time.Duration `env:"DEF_T" envDefault:"2s"`
 }
 
 func TestConfigLoad(t *testing.T) {
@@ -27,6 +31,7 @@ func TestConfigLoad(t *testing.T) {
 		"NESTED_LE_F":    "true",
 		"FROM_FILE":      "testdata/from.data",
 		"OTHERFILE_FILE": "testdata/not.data",
+		"LE_T":           "1500ms",
 	}
 	// Setup environment
 	for k, v := range env {
@@ -44,8 +49,22 @@ func TestConfigLoad(t *testing.T) {
 		N: Nested{
 			F: true,
 		},
-		D: "default_conf",
+		D:  "default_conf",
+		T:  1500 * time.Millisecond,
+		DT: 2 * time.Second,
 	}
 
 	assert.Equal(t, expected, conf)
 }
+
+func TestServiceConfHidesSecrets(t *testing.T) {
+	conf := ServiceConf{
+		Clients:    `[{"name": "web", "key": "web-key"}]`,
+		Injections: `{"newad": [{"param": "token", "from": "static", "value": "s3cr3t"}]}`,
+	}
+	printed, err := json.Marshal(conf)
+	assert.NoError(t, err)
+	for _, secret := range []string{"web-key", "s3cr3t"} {
+		assert.NotContains(t, string(printed), secret)
+	}
+}
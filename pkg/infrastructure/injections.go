@@ -0,0 +1,102 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+)
+
+// paramInjection is how an injected param is written on Injections
+type paramInjection struct {
+	Param  string                   `json:"param"`
+	From   handlers.InjectionSource `json:"from"`
+	Header string                   `json:"header"`
+	Value  string                   `json:"value"`
+	// Mode override, the default, or default, to only set the param when the caller didn't
+	Mode string `json:"mode"`
+}
+
+// ParseInjections reads the params injected on each command on Injections
+func (conf ServiceConf) ParseInjections() (map[string][]handlers.ParamInjection, error) {
+	injections := make(map[string][]handlers.ParamInjection)
+	if conf.Injections == "" {
+		return injections, nil
+	}
+	var file map[string][]paramInjection
+	if err := json.Unmarshal([]byte(conf.Injections), &file); err != nil {
+		return nil, fmt.Errorf("invalid injections: %s", err)
+	}
+	for cmd, list := range file {
+		for _, entry := range list {
+			injection, err := entry.parse()
+			if err != nil {
+				return nil, fmt.Errorf("invalid injection of %q on %s: %s", entry.Param, cmd, err)
+			}
+			injections[cmd] = append(injections[cmd], injection)
+		}
+	}
+	return injections, nil
+}
+
+// parse checks the injection
+func (p paramInjection) parse() (handlers.ParamInjection, error) {
+	injection := handlers.ParamInjection{
+		Param:  p.Param,
+		Source: p.From,
+		Header: p.Header,
+		Value:  p.Value,
+	}
+	if p.Param == "" {
+		return injection, fmt.Errorf("missing param")
+	}
+	switch p.Mode {
+	case "", "override":
+		injection.Override = true
+	case "default":
+	default:
+		return injection, fmt.Errorf("unknown mode %q", p.Mode)
+	}
+	switch p.From {
+	case handlers.InjectClientIP, handlers.InjectClient:
+	case handlers.InjectHeader:
+		if p.Header == "" {
+			return injection, fmt.Errorf("missing header")
+		}
+	case handlers.InjectStatic:
+		if p.Value == "" {
+			return injection, fmt.Errorf("missing value")
+		}
+	default:
+		return injection, fmt.Errorf("unknown source %q", p.From)
+	}
+	return injection, nil
+}
+
+// ParseTrustedProxies reads the networks on TrustedProxies. Single
+// addresses are networks of their own
+func (conf ServiceConf) ParseTrustedProxies() ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range splitList(conf.TrustedProxies) {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
@@ -0,0 +1,56 @@
+package infrastructure
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+)
+
+func TestParseInjections(t *testing.T) {
+	injections, err := ServiceConf{Injections: `{
+		"*": [{"param": "source", "from": "static", "value": "api"}],
+		"newad": [
+			{"param": "remote_addr", "from": "client_ip"},
+			{"param": "remote_browser", "from": "header", "header": "User-Agent", "mode": "default"},
+			{"param": "client", "from": "client", "mode": "override"}
+		]
+	}`}.ParseInjections()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]handlers.ParamInjection{
+		"*": {{Param: "source", Source: handlers.InjectStatic, Value: "api", Override: true}},
+		"newad": {
+			{Param: "remote_addr", Source: handlers.InjectClientIP, Override: true},
+			{Param: "remote_browser", Source: handlers.InjectHeader, Header: "User-Agent"},
+			{Param: "client", Source: handlers.InjectClient, Override: true},
+		},
+	}, injections)
+
+	for name, list := range map[string]string{
+		"json":   `[]`,
+		"param":  `{"newad": [{"from": "client"}]}`,
+		"source": `{"newad": [{"param": "a", "from": "cookie"}]}`,
+		"header": `{"newad": [{"param": "a", "from": "header"}]}`,
+		"value":  `{"newad": [{"param": "a", "from": "static"}]}`,
+		"mode":   `{"newad": [{"param": "a", "from": "client", "mode": "append"}]}`,
+	} {
+		_, err := ServiceConf{Injections: list}.ParseInjections()
+		assert.Error(t, err, name)
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ServiceConf{TrustedProxies: "10.0.0.0/8|192.168.1.1|::1"}.ParseTrustedProxies()
+	assert.NoError(t, err)
+	assert.Len(t, proxies, 3)
+	assert.True(t, proxies[0].Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, proxies[1].Contains(net.ParseIP("192.168.1.1")))
+	assert.False(t, proxies[1].Contains(net.ParseIP("192.168.1.2")))
+	assert.True(t, proxies[2].Contains(net.ParseIP("::1")))
+
+	for _, list := range []string{"10.0.0.0/33", "proxy"} {
+		_, err := ServiceConf{TrustedProxies: list}.ParseTrustedProxies()
+		assert.Error(t, err, list)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/Yapo/logger"
 	"github.com/prometheus/client_golang/prometheus"
@@ -138,6 +139,174 @@ func sanitizeMetricName(name string) string {
 	return endStartUnderscore.ReplaceAllString(str, "")
 }
 
+// TransCollector bundles the metrics reported by the trans client.
+// A nil TransCollector is valid and reports nothing
+type TransCollector struct {
+	busy         *prometheus.CounterVec
+	breakerState *prometheus.GaugeVec
+	breakerTrips *prometheus.CounterVec
+	queueDepth   *prometheus.GaugeVec
+	queueWait    *prometheus.HistogramVec
+	rejected     *prometheus.CounterVec
+	limit        prometheus.Gauge
+	hedges       *prometheus.CounterVec
+}
+
+// NewTransCollector creates a new instance of TransCollector
+func (*Prometheus) NewTransCollector() *TransCollector {
+	c := TransCollector{
+		busy: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "trans_busy_total",
+				Help: "A counter of busy greetings received from trans.",
+			},
+			[]string{"command"},
+		),
+		breakerState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "trans_breaker_state",
+				Help: "The circuit breaker state of each trans backend: 0 closed, 1 half open, 2 open.",
+			},
+			[]string{"backend"},
+		),
+		breakerTrips: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "trans_breaker_transitions_total",
+				Help: "A counter of the circuit breaker state changes of each trans backend.",
+			},
+			[]string{"backend", "state"},
+		),
+		queueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "trans_queue_depth",
+				Help: "A gauge of commands waiting for a trans connection slot.",
+			},
+			[]string{"command", "priority"},
+		),
+		queueWait: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "trans_queue_wait_seconds",
+				Help:    "A histogram of the time commands waited for a trans connection slot.",
+				Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+			},
+			[]string{"command", "priority"},
+		),
+		rejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "trans_rejected_total",
+				Help: "A counter of commands rejected before reaching trans.",
+			},
+			[]string{"command", "reason"},
+		),
+		limit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "trans_concurrency_limit",
+			Help: "A gauge of the adaptive limit of commands in flight to trans.",
+		}),
+		hedges: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "trans_hedges_total",
+				Help: "A counter of hedged commands, by the attempt that answered first.",
+			},
+			[]string{"command", "winner"},
+		),
+	}
+	prometheus.MustRegister(
+		c.busy, c.breakerState, c.breakerTrips, c.queueDepth, c.queueWait, c.rejected, c.limit, c.hedges,
+	)
+	return &c
+}
+
+// CollectBusy increments the busy counter of the given command
+func (c *TransCollector) CollectBusy(command string) {
+	if c == nil {
+		return
+	}
+	c.busy.WithLabelValues(command).Inc()
+}
+
+// CollectBreakerState records the new circuit breaker state of the given backend
+func (c *TransCollector) CollectBreakerState(backend string, state breakerState) {
+	if c == nil {
+		return
+	}
+	c.breakerState.WithLabelValues(backend).Set(float64(state))
+	c.breakerTrips.WithLabelValues(backend, state.String()).Inc()
+}
+
+// CollectQueueDepth adds delta to the commands of the given kind and priority waiting for a slot
+func (c *TransCollector) CollectQueueDepth(command, priority string, delta float64) {
+	if c == nil {
+		return
+	}
+	c.queueDepth.WithLabelValues(command, priority).Add(delta)
+}
+
+// CollectQueueWait records how long a command of the given priority waited for a slot
+func (c *TransCollector) CollectQueueWait(command, priority string, wait time.Duration) {
+	if c == nil {
+		return
+	}
+	c.queueWait.WithLabelValues(command, priority).Observe(wait.Seconds())
+}
+
+// CollectRejected increments the counter of commands rejected for the given reason
+func (c *TransCollector) CollectRejected(command, reason string) {
+	if c == nil {
+		return
+	}
+	c.rejected.WithLabelValues(command, reason).Inc()
+}
+
+// CollectLimit records the current adaptive concurrency limit
+func (c *TransCollector) CollectLimit(limit int) {
+	if c == nil {
+		return
+	}
+	c.limit.Set(float64(limit))
+}
+
+// CollectHedge counts a hedged command, by whether the hedge or the first attempt answered first
+func (c *TransCollector) CollectHedge(command string, hedge bool) {
+	if c == nil {
+		return
+	}
+	winner := "first"
+	if hedge {
+		winner = "hedge"
+	}
+	c.hedges.WithLabelValues(command, winner).Inc()
+}
+
+// ClientCollector counts the requests of each client.
+// A nil ClientCollector is valid and reports nothing
+type ClientCollector struct {
+	requests *prometheus.CounterVec
+}
+
+// NewClientCollector creates a new instance of ClientCollector
+func (*Prometheus) NewClientCollector() *ClientCollector {
+	c := ClientCollector{
+		requests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "client_requests_total",
+				Help: "A counter of requests by client, command and whether they were allowed.",
+			},
+			[]string{"client", "command", "result"},
+		),
+	}
+	prometheus.MustRegister(c.requests)
+	return &c
+}
+
+// CollectClientRequest counts a request of the client, with its result:
+// allowed, denied or unauthenticated
+func (c *ClientCollector) CollectClientRequest(client, command, result string) {
+	if c == nil {
+		return
+	}
+	c.requests.WithLabelValues(client, command, result).Inc()
+}
+
 // EventCollector is a Collector that bundles a set of Counters that all share the
 // same descriptor, but have different values for their variable labels.
 type EventCollector struct {
@@ -0,0 +1,61 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+)
+
+// responsePolicy is how a response policy is written on ResponsePolicies
+type responsePolicy struct {
+	Allow []string   `json:"allow"`
+	Deny  []string   `json:"deny"`
+	Mask  []maskRule `json:"mask"`
+}
+
+// maskRule is how a mask rule is written
+type maskRule struct {
+	Key      string `json:"key"`
+	KeepLast int    `json:"keep_last"`
+}
+
+// ParseResponsePolicies reads the response policy of each command on ResponsePolicies
+func (conf ServiceConf) ParseResponsePolicies() (map[string]handlers.ResponsePolicy, error) {
+	policies := make(map[string]handlers.ResponsePolicy)
+	if conf.ResponsePolicies == "" {
+		return policies, nil
+	}
+	var file map[string]responsePolicy
+	if err := json.Unmarshal([]byte(conf.ResponsePolicies), &file); err != nil {
+		return nil, fmt.Errorf("invalid response policies: %s", err)
+	}
+	for cmd, policy := range file {
+		parsed, err := policy.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid response policy of %s: %s", cmd, err)
+		}
+		policies[cmd] = parsed
+	}
+	return policies, nil
+}
+
+// parse checks the patterns and mask rules of the policy
+func (p responsePolicy) parse() (handlers.ResponsePolicy, error) {
+	policy := handlers.ResponsePolicy{Allow: p.Allow, Deny: p.Deny}
+	patterns := append(append([]string{}, p.Allow...), p.Deny...)
+	for _, rule := range p.Mask {
+		if rule.KeepLast < 0 {
+			return policy, fmt.Errorf("negative keep_last on %q", rule.Key)
+		}
+		patterns = append(patterns, rule.Key)
+		policy.Mask = append(policy.Mask, handlers.MaskRule{Key: rule.Key, KeepLast: rule.KeepLast})
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return policy, fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return policy, nil
+}
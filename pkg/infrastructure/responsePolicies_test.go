@@ -0,0 +1,36 @@
+package infrastructure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+)
+
+func TestParseResponsePolicies(t *testing.T) {
+	policies, err := ServiceConf{ResponsePolicies: `{
+		"get_account": {
+			"allow": ["account_id", "email", "phone*"],
+			"mask": [{"key": "phone*", "keep_last": 4}]
+		},
+		"*": {"deny": ["passwd", "salt_*"]}
+	}`}.ParseResponsePolicies()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]handlers.ResponsePolicy{
+		"get_account": {
+			Allow: []string{"account_id", "email", "phone*"},
+			Mask:  []handlers.MaskRule{{Key: "phone*", KeepLast: 4}},
+		},
+		"*": {Deny: []string{"passwd", "salt_*"}},
+	}, policies)
+
+	for name, list := range map[string]string{
+		"json":      `[]`,
+		"pattern":   `{"get_account": {"deny": ["salt_["]}}`,
+		"empty":     `{"get_account": {"allow": [""]}}`,
+		"keep last": `{"get_account": {"mask": [{"key": "phone", "keep_last": -1}]}}`,
+	} {
+		_, err := ServiceConf{ResponsePolicies: list}.ParseResponsePolicies()
+		assert.Error(t, err, name)
+	}
+}
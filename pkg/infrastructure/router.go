@@ -16,6 +16,8 @@ type Route struct {
 	Method  string
 	Pattern string
 	Handler handlers.Handler
+	// Public routes are not authenticated
+	Public bool
 }
 
 type routeGroups struct {
@@ -30,9 +32,11 @@ type WrapperFunc func(pattern string, handler http.HandlerFunc) http.HandlerFunc
 // Routes is an array of routes with a common prefix
 type Routes []routeGroups
 
-// RouterMaker gathers route and wrapper information to build a router
+// RouterMaker gathers route and wrapper information to build a router.
+// Auth, if set, wraps every route that is not public
 type RouterMaker struct {
 	Logger        loggers.Logger
+	Auth          WrapperFunc
 	WrapperFuncs  []WrapperFunc
 	WithProfiling bool
 	Routes        Routes
@@ -46,9 +50,15 @@ func (maker *RouterMaker) NewRouter() http.Handler {
 		for _, route := range routeGroup.Groups {
 			hLogger := loggers.MakeJSONHandlerLogger(maker.Logger)
 			handler := handlers.MakeJSONHandlerFunc(route.Handler, hLogger)
+			if maker.Auth != nil && !route.Public {
+				handler = maker.Auth(route.Pattern, handler)
+			}
 			for _, wrapFunc := range maker.WrapperFuncs {
 				handler = wrapFunc(route.Pattern, handler)
 			}
+			if rewriter, ok := route.Handler.(handlers.RequestRewriter); ok {
+				handler = rewriter.Rewrite(handler)
+			}
 			subRouter.
 				Methods(route.Method).
 				Path(route.Pattern).
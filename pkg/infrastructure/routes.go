@@ -0,0 +1,90 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+)
+
+// routeConf is how a REST-style route is written on Routes
+type routeConf struct {
+	Name    string `json:"name"`
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Command string `json:"command"`
+	// Params where each trans param is taken from, written as location:name,
+	// like path:email, query:page or body:id
+	Params map[string]string `json:"params"`
+	// Response renames the keys of the response
+	Response map[string]string `json:"response"`
+}
+
+// ParseRoutes reads the REST-style routes on Routes. Their commands are executed by trans
+func (conf ServiceConf) ParseRoutes(trans *handlers.TransHandler) ([]Route, error) {
+	if conf.Routes == "" {
+		return nil, nil
+	}
+	var list []routeConf
+	if err := json.Unmarshal([]byte(conf.Routes), &list); err != nil {
+		return nil, fmt.Errorf("invalid routes: %s", err)
+	}
+	routes := make([]Route, 0, len(list))
+	for _, route := range list {
+		handler, err := route.parse(trans)
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %s %s: %s", route.Method, route.Path, err)
+		}
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("Execute %s", route.Command)
+		}
+		routes = append(routes, Route{
+			Name:    name,
+			Method:  strings.ToUpper(route.Method),
+			Pattern: route.Path,
+			Handler: handler,
+		})
+	}
+	return routes, nil
+}
+
+// parse checks the route and creates its handler
+func (r routeConf) parse(trans *handlers.TransHandler) (*handlers.RouteHandler, error) {
+	switch strings.ToUpper(r.Method) {
+	case "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		return nil, fmt.Errorf("unknown method")
+	}
+	if !strings.HasPrefix(r.Path, "/") {
+		return nil, fmt.Errorf("the path must start with /")
+	}
+	if r.Command == "" {
+		return nil, fmt.Errorf("missing command")
+	}
+	handler := &handlers.RouteHandler{
+		Trans:    trans,
+		Command:  r.Command,
+		Params:   make(map[string]handlers.RouteParam),
+		Response: r.Response,
+	}
+	for param, source := range r.Params {
+		parts := strings.SplitN(source, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid source %q of %s", source, param)
+		}
+		location := handlers.ParamLocation(parts[0])
+		switch location {
+		case handlers.ParamFromQuery, handlers.ParamFromBody:
+		case handlers.ParamFromPath:
+			if !strings.Contains(r.Path, "{"+parts[1]+"}") && !strings.Contains(r.Path, "{"+parts[1]+":") {
+				return nil, fmt.Errorf("%s is not on the path", parts[1])
+			}
+		default:
+			return nil, fmt.Errorf("invalid source %q of %s", source, param)
+		}
+		handler.Params[param] = handlers.RouteParam{From: location, Name: parts[1]}
+	}
+	return handler, nil
+}
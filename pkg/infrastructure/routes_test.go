@@ -0,0 +1,115 @@
+package infrastructure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/handlers"
+	"gopkg.in/gorilla/mux.v1"
+)
+
+func TestParseRoutes(t *testing.T) {
+	trans := &handlers.TransHandler{}
+	routes, err := ServiceConf{}.ParseRoutes(trans)
+	assert.NoError(t, err)
+	assert.Empty(t, routes)
+
+	routes, err = ServiceConf{Routes: `[
+		{"method": "get", "path": "/accounts/{email}", "command": "get_account",
+		 "params": {"email": "path:email"}, "response": {"account_id": "id"}},
+		{"name": "Bump an ad", "method": "POST", "path": "/ads/{ad_id:[0-9]+}/bump", "command": "bump_ad",
+		 "params": {"ad_id": "path:ad_id", "days": "body:days", "source": "query:from"}}
+	]`}.ParseRoutes(trans)
+	assert.NoError(t, err)
+	assert.Equal(t, []Route{
+		{
+			Name:    "Execute get_account",
+			Method:  "GET",
+			Pattern: "/accounts/{email}",
+			Handler: &handlers.RouteHandler{
+				Trans:    trans,
+				Command:  "get_account",
+				Params:   map[string]handlers.RouteParam{"email": {From: handlers.ParamFromPath, Name: "email"}},
+				Response: map[string]string{"account_id": "id"},
+			},
+		},
+		{
+			Name:    "Bump an ad",
+			Method:  "POST",
+			Pattern: "/ads/{ad_id:[0-9]+}/bump",
+			Handler: &handlers.RouteHandler{
+				Trans:   trans,
+				Command: "bump_ad",
+				Params: map[string]handlers.RouteParam{
+					"ad_id":  {From: handlers.ParamFromPath, Name: "ad_id"},
+					"days":   {From: handlers.ParamFromBody, Name: "days"},
+					"source": {From: handlers.ParamFromQuery, Name: "from"},
+				},
+			},
+		},
+	}, routes)
+
+	for name, list := range map[string]string{
+		"json":     `{}`,
+		"method":   `[{"method": "HEAD", "path": "/accounts", "command": "get_account"}]`,
+		"path":     `[{"method": "GET", "path": "accounts", "command": "get_account"}]`,
+		"command":  `[{"method": "GET", "path": "/accounts"}]`,
+		"source":   `[{"method": "GET", "path": "/accounts", "command": "get_account", "params": {"email": "email"}}]`,
+		"location": `[{"method": "GET", "path": "/accounts", "command": "get_account", "params": {"email": "form:email"}}]`,
+		"var":      `[{"method": "GET", "path": "/accounts", "command": "get_account", "params": {"email": "path:email"}}]`,
+	} {
+		_, err := ServiceConf{Routes: list}.ParseRoutes(trans)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestAuthenticateRoute(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	logger.On("Info")
+	routes, err := ServiceConf{Routes: `[
+		{"method": "POST", "path": "/accounts/{id}", "command": "get_account", "params": {"account_id": "path:id"}}
+	]`}.ParseRoutes(&handlers.TransHandler{})
+	assert.NoError(t, err)
+	authenticator, err := NewAuthenticator(
+		ServiceConf{Clients: testClients, SignatureMaxAge: time.Minute},
+		&logger,
+		nil,
+	)
+	assert.NoError(t, err)
+	rewriter := routes[0].Handler.(handlers.RequestRewriter)
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Client", handlers.ClientFrom(r.Context()))
+	}
+	handler := rewriter.Rewrite(authenticator.Authenticate("/accounts/{id}", echo))
+	router := mux.NewRouter()
+	router.HandleFunc("/accounts/{id}", handler)
+
+	// param restrictions apply to the rewritten params
+	for id, code := range map[string]int{"12": http.StatusOK, "x": http.StatusForbidden} {
+		r := httptest.NewRequest("POST", "/accounts/"+id, nil)
+		r.Header.Set("X-Api-Key", "web-key")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		assert.Equal(t, code, w.Code, id)
+	}
+
+	// signatures cover the body that was sent
+	body := `{"unused": true}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	r := httptest.NewRequest("POST", "/accounts/12", strings.NewReader(body))
+	r.Header.Set("X-Client", "backoffice")
+	r.Header.Set("X-Timestamp", now)
+	r.Header.Set("X-Nonce", "1")
+	signed := http.Header{"X-Nonce": {"1"}}
+	r.Header.Set("X-Signature", Sign([]byte("s3cr3t"), now, "POST", "/accounts/12", signed, []byte(body)))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "backoffice", w.Header().Get("X-Client"))
+}
@@ -0,0 +1,90 @@
+package infrastructure
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// schemasFile is how the param schemas are written on Schemas
+type schemasFile struct {
+	// Strict rejects undeclared params on every command, unless the command says otherwise
+	Strict   bool                     `json:"strict"`
+	Commands map[string]commandSchema `json:"commands"`
+}
+
+// commandSchema is how the params of a command are declared
+type commandSchema struct {
+	Strict *bool                  `json:"strict"`
+	Params map[string]paramSchema `json:"params"`
+}
+
+// paramSchema is how a param is declared
+type paramSchema struct {
+	Type      domain.ParamType `json:"type"`
+	Required  bool             `json:"required"`
+	Pattern   string           `json:"pattern"`
+	MaxLength int              `json:"max_length"`
+	Enum      []string         `json:"enum"`
+	Blob      bool             `json:"blob"`
+}
+
+// ParseSchemas reads the param schemas of each command on Schemas
+func (conf ServiceConf) ParseSchemas() (map[string]domain.CommandSchema, error) {
+	schemas := make(map[string]domain.CommandSchema)
+	if conf.Schemas == "" {
+		return schemas, nil
+	}
+	var file schemasFile
+	if err := json.Unmarshal([]byte(conf.Schemas), &file); err != nil {
+		return nil, fmt.Errorf("invalid schemas: %s", err)
+	}
+	for cmd, command := range file.Commands {
+		schema := domain.CommandSchema{
+			Params: make(map[string]domain.ParamSchema),
+			Strict: file.Strict,
+		}
+		if command.Strict != nil {
+			schema.Strict = *command.Strict
+		}
+		for key, param := range command.Params {
+			paramSchema, err := param.parse()
+			if err != nil {
+				return nil, fmt.Errorf("invalid schema of %s on %s: %s", key, cmd, err)
+			}
+			schema.Params[key] = paramSchema
+		}
+		schemas[cmd] = schema
+	}
+	return schemas, nil
+}
+
+// parse checks the declaration of the param
+func (p paramSchema) parse() (domain.ParamSchema, error) {
+	schema := domain.ParamSchema{
+		Type:      p.Type,
+		Required:  p.Required,
+		MaxLength: p.MaxLength,
+		Enum:      p.Enum,
+		Blob:      p.Blob,
+	}
+	switch p.Type {
+	case domain.ParamTypeAny, domain.ParamTypeString, domain.ParamTypeInteger,
+		domain.ParamTypeNumber, domain.ParamTypeBoolean:
+	default:
+		return schema, fmt.Errorf("unknown type %q", p.Type)
+	}
+	if p.MaxLength < 0 {
+		return schema, fmt.Errorf("negative max length")
+	}
+	if p.Pattern != "" {
+		re, err := regexp.Compile("^(?:" + p.Pattern + ")$")
+		if err != nil {
+			return schema, err
+		}
+		schema.Pattern = re
+	}
+	return schema, nil
+}
@@ -0,0 +1,61 @@
+package infrastructure
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestParseSchemas(t *testing.T) {
+	schemas, err := ServiceConf{Schemas: `{
+		"strict": true,
+		"commands": {
+			"get_account": {"params": {
+				"account_id": {"type": "integer", "required": true},
+				"email": {"type": "string", "pattern": "[a-z]+@yapo.cl", "max_length": 60}
+			}},
+			"newad": {"strict": false, "params": {
+				"type": {"enum": ["sell", "buy"]},
+				"image": {"blob": true}
+			}}
+		}
+	}`}.ParseSchemas()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]domain.CommandSchema{
+		"get_account": {
+			Strict: true,
+			Params: map[string]domain.ParamSchema{
+				"account_id": {Type: domain.ParamTypeInteger, Required: true},
+				"email": {
+					Type:      domain.ParamTypeString,
+					Pattern:   regexp.MustCompile("^(?:[a-z]+@yapo.cl)$"),
+					MaxLength: 60,
+				},
+			},
+		},
+		"newad": {
+			Params: map[string]domain.ParamSchema{
+				"type":  {Enum: []string{"sell", "buy"}},
+				"image": {Blob: true},
+			},
+		},
+	}, schemas)
+
+	schemas, err = ServiceConf{}.ParseSchemas()
+	assert.NoError(t, err)
+	assert.Empty(t, schemas)
+}
+
+func TestParseSchemasInvalid(t *testing.T) {
+	for name, schemas := range map[string]string{
+		"json":       `[]`,
+		"type":       `{"commands": {"newad": {"params": {"price": {"type": "money"}}}}}`,
+		"pattern":    `{"commands": {"newad": {"params": {"price": {"pattern": "("}}}}}`,
+		"max length": `{"commands": {"newad": {"params": {"price": {"max_length": -1}}}}}`,
+	} {
+		_, err := ServiceConf{Schemas: schemas}.ParseSchemas()
+		assert.Error(t, err, name)
+	}
+}
@@ -4,27 +4,58 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"encoding/base64"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
-	"golang.org/x/text/encoding/charmap"
-
-	"github.com/eapache/go-resiliency/retrier"
 	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
 	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/loggers"
 	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/repository/services"
 )
 
+// transOK status returned by trans when a command succeeds
+const transOK = "TRANS_OK"
+
+// Codes of the errors found talking to trans, as opposed to the ones returned by trans
+const (
+	// transUnavailable trans couldn't be reached
+	transUnavailable = "TRANS_UNAVAILABLE"
+	// transBadResponse trans answered something that isn't a valid response
+	transBadResponse = "TRANS_BAD_RESPONSE"
+	// transTimeout trans didn't answer before the deadline
+	transTimeout = "TRANS_TIMEOUT"
+	// transConnectionLost the connection was lost while sending the command
+	transConnectionLost = "TRANS_CONNECTION_LOST"
+)
+
+// upstreamError classifies an error talking to trans as an upstream failure
+func upstreamError(code, message string) domain.TransError {
+	return domain.TransError{
+		Class:   domain.ErrorClassUpstream,
+		Code:    code,
+		Message: message,
+	}
+}
+
 // trans struct definition
 type trans struct {
 	conf            TransConf
 	logger          loggers.Logger
 	allowedCommands []string
+	dryRunCommands  []string
+	encoder         transEncoder
+	charset         transCharset
+	metrics         *TransCollector
+	backends        *transBackendPool
+	retry           transRetryPolicy
+	timeouts        map[string]time.Duration
+	bulkhead        *transBulkhead
+	limiter         *transLimiter
+	tls             *transTLS
+	hedger          *transHedger
 }
 
 // textProtocolTransFactory is a auxiliar struct to create trans on demand
@@ -32,18 +63,69 @@ type textProtocolTransFactory struct {
 	conf            TransConf
 	logger          loggers.Logger
 	allowedCommands []string
+	dryRunCommands  []string
+	encoder         transEncoder
+	charset         transCharset
+	metrics         *TransCollector
+	backends        *transBackendPool
+	retry           transRetryPolicy
+	timeouts        map[string]time.Duration
+	bulkhead        *transBulkhead
+	limiter         *transLimiter
+	tls             *transTLS
+	hedger          *transHedger
 }
 
-// NewTextProtocolTransFactory initialize a services.TransFactory
+// NewTextProtocolTransFactory initialize a services.TransFactory. Trans events
+// are reported to metrics, if given. An error is returned if the configuration is not valid
 func NewTextProtocolTransFactory(
 	conf TransConf,
 	logger loggers.Logger,
-) services.TransFactory {
-	return &textProtocolTransFactory{
+	metrics *TransCollector,
+) (services.TransFactory, error) {
+	charset, err := newTransCharset(conf.Charset)
+	if err != nil {
+		return nil, err
+	}
+	if conf.RetryAfter != 0 {
+		logger.Warn("TRANS_RETRY is no longer used, set TRANS_RETRY_BACKOFF instead\n")
+	}
+	timeouts, err := parseCommandTimeouts(conf.CommandTimeouts)
+	if err != nil {
+		return nil, err
+	}
+	bulkhead, err := newTransBulkhead(conf, metrics)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf, err := newTransTLS(conf, logger)
+	if err != nil {
+		return nil, err
+	}
+	hedger, err := newTransHedger(conf)
+	if err != nil {
+		return nil, err
+	}
+	factory := &textProtocolTransFactory{
 		conf:            conf,
 		logger:          logger,
 		allowedCommands: strings.Split(conf.AllowedCommands, "|"),
+		dryRunCommands:  strings.Split(conf.DryRunCommands, "|"),
+		encoder:         newTransEncoder(conf, charset),
+		charset:         charset,
+		metrics:         metrics,
+		retry:           newTransRetryPolicy(conf),
+		timeouts:        timeouts,
+		bulkhead:        bulkhead,
+		limiter:         newTransLimiter(conf, metrics),
+		tls:             tlsConf,
+		hedger:          hedger,
 	}
+	factory.backends, err = newTransBackendPool(conf, logger, metrics, factory.probe)
+	if err != nil {
+		return nil, err
+	}
+	return factory, nil
 }
 
 // MakeTransHandler initialize a services.TransHandler on demand
@@ -52,12 +134,53 @@ func (t *textProtocolTransFactory) MakeTransHandler() services.TransHandler {
 		conf:            t.conf,
 		logger:          t.logger,
 		allowedCommands: t.allowedCommands,
+		dryRunCommands:  t.dryRunCommands,
+		encoder:         t.encoder,
+		charset:         t.charset,
+		metrics:         t.metrics,
+		backends:        t.backends,
+		retry:           t.retry,
+		timeouts:        t.timeouts,
+		bulkhead:        t.bulkhead,
+		limiter:         t.limiter,
+		tls:             t.tls,
+		hedger:          t.hedger,
 	}
 }
 
-// SendCommand use a socket connection to send commands to trans port
-func (handler *trans) SendCommand(cmd string, transParams []domain.TransParams) (map[string]string, error) {
-	respMap := make(map[string]string)
+// probe checks if the trans at the address endpoint is healthy, sending it a transinfo command
+func (t *textProtocolTransFactory) probe(address string) error {
+	handler := t.MakeTransHandler().(*trans)
+	ctx, cancel := context.WithTimeout(context.Background(), handler.commandTimeout("transinfo"))
+	defer cancel()
+	conn, err := handler.dial(ctx, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close() //nolint: errcheck, megacheck
+
+	buf, err := t.encoder.appendCmd(nil, domain.TransCommand{Command: "transinfo"})
+	if err != nil {
+		return err
+	}
+	fields, err := handler.sendWithContext(ctx, conn, buf)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if field.Key == "status" && field.Value != transOK {
+			return fmt.Errorf("transinfo status %s", field.Value)
+		}
+	}
+	return nil
+}
+
+// SendCommand use a socket connection to send commands to trans port. The
+// command is limited to its timeout on CommandTimeouts, or Timeout seconds,
+// and it is abandoned as soon as ctx is done. Commands over the adaptive
+// limit are shed, and commands over the concurrency limits wait for a free slot first
+func (handler *trans) SendCommand(ctx context.Context, command domain.TransCommand) ([]domain.TransField, error) {
+	cmd := command.Command
 	// check if the command is allowed; if not, return error
 	valid := handler.isAllowedCommand(cmd)
 	if !valid {
@@ -65,30 +188,171 @@ func (handler *trans) SendCommand(cmd string, transParams []domain.TransParams)
 			"invalid command - commands allowed: %s",
 			handler.allowedCommands,
 		)
-		respMap["error"] = err.Error()
 		handler.logger.Error(err.Error())
-		return respMap, err
+		return []domain.TransField{{Key: "error", Value: err.Error()}}, err
 	}
-	conn, err := handler.connect()
+	if command.DryRun && !handler.isDryRunCommand(cmd) {
+		err := fmt.Errorf("dry run is not allowed for command %s", cmd)
+		handler.logger.Error(err.Error())
+		return []domain.TransField{{Key: "error", Value: err.Error()}}, err
+	}
+	// encode the command before connecting, so invalid params don't reach trans
+	buf, err := handler.encoder.appendCmd(nil, command)
 	if err != nil {
-		handler.logger.Error("Error connecting to trans: %s\n", err.Error())
-		return respMap, fmt.Errorf("Error connecting with trans server")
+		handler.logger.Error("Error encoding command %s: %s\n", cmd, err)
+		return []domain.TransField{{Key: "error", Value: err.Error()}}, err
 	}
-	defer conn.Close() //nolint: errcheck, megacheck
-
-	// initiate the context so the request can timeout
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		time.Duration(handler.conf.Timeout)*time.Second,
-	)
+	// limit the context so the request can timeout
+	ctx, cancel := context.WithTimeout(ctx, handler.commandTimeout(cmd))
 	defer cancel()
 
-	respMap, err = handler.sendWithContext(ctx, conn, cmd, transParams)
+	release, err := handler.acquire(ctx, command)
+	if err != nil {
+		handler.logger.Error("Error Sending command %s: %s\n", cmd, err)
+		return []domain.TransField{}, err
+	}
+	defer release()
+
+	resp, err := handler.sendWithRetries(ctx, command, buf)
 	if err != nil {
 		handler.logger.Error("Error Sending command %s: %s\n", cmd, err)
 	}
 
-	return respMap, err
+	return resp, err
+}
+
+// acquire takes a place for the command on the adaptive limiter and a slot
+// on the bulkhead. The returned function gives both back
+func (handler *trans) acquire(ctx context.Context, command domain.TransCommand) (func(), error) {
+	unlimit, err := handler.limiter.acquire(command.Command, command.Priority)
+	if err != nil {
+		return nil, err
+	}
+	release, err := handler.bulkhead.acquire(ctx, command.Command, command.Priority)
+	if err != nil {
+		unlimit()
+		return nil, err
+	}
+	return func() {
+		release()
+		unlimit()
+	}, nil
+}
+
+// acquireHedge takes a place on the adaptive limiter and a slot on the
+// bulkhead for a hedge of the command, only if both are free right away
+func (handler *trans) acquireHedge(command domain.TransCommand) (func(), bool) {
+	unlimit, ok := handler.limiter.tryAcquire(command.Priority)
+	if !ok {
+		return nil, false
+	}
+	release, ok := handler.bulkhead.tryAcquire(command.Command)
+	if !ok {
+		unlimit()
+		return nil, false
+	}
+	return func() {
+		release()
+		unlimit()
+	}, true
+}
+
+// sendWithRetries sends the command to trans. If it fails in a way the retry
+// policy allows, the command is sent again after a backoff, as long as the
+// context deadline allows waiting for it. Each attempt is limited to
+// AttemptTimeout, if set
+func (handler *trans) sendWithRetries(
+	ctx context.Context,
+	command domain.TransCommand,
+	buf []byte,
+) ([]domain.TransField, error) {
+	cmd := command.Command
+	for retry := 0; ; retry++ {
+		start := time.Now()
+		resp, err := handler.sendAttempt(ctx, command, buf)
+		handler.limiter.observe(time.Since(start), handler.commandTimeout(cmd), err)
+		if _, busy := err.(domain.BusyError); busy {
+			handler.metrics.CollectBusy(cmd)
+		}
+		if ctx.Err() != nil || !handler.retry.retryable(cmd, retry, resp, err) {
+			return resp, err
+		}
+		delay := handler.retry.delay(retry)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+		handler.logger.Debug("Retrying command %s in %s: %v\n", cmd, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return resp, err
+		}
+	}
+}
+
+// sendAttempt sends the command once, within AttemptTimeout if set. Commands
+// marked to be hedged are sent again to another backend if they are slow
+func (handler *trans) sendAttempt(
+	ctx context.Context,
+	command domain.TransCommand,
+	buf []byte,
+) ([]domain.TransField, error) {
+	cmd := command.Command
+	if handler.conf.AttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handler.conf.AttemptTimeout)
+		defer cancel()
+	}
+	if delay, ok := handler.hedger.delay(cmd); ok && handler.backends.size() > 1 {
+		return handler.sendHedged(ctx, command, buf, delay)
+	}
+	start := time.Now()
+	resp, err := handler.sendOnce(ctx, buf, nil)
+	if err == nil {
+		handler.hedger.observe(cmd, time.Since(start))
+	}
+	return resp, err
+}
+
+// sendOnce opens a new connection to trans and sends the command through it.
+// Busy backends count as failed, so they are ejected if they keep being busy.
+// Timeouts and invalid responses count as failures of the backend circuit
+// breaker. The backends on used are skipped, and the one picked is added to it
+func (handler *trans) sendOnce(ctx context.Context, buf []byte, used *transBackendSet) ([]domain.TransField, error) {
+	conn, backend, err := handler.connect(ctx, used)
+	if err != nil && ctx.Err() != nil {
+		return []domain.TransField{}, contextError(ctx)
+	}
+	if err == errBreakerOpen {
+		return []domain.TransField{}, domain.TransError{
+			Class:   domain.ErrorClassUnavailable,
+			Code:    transUnavailable,
+			Message: "every trans backend is failing, circuit breaker is open",
+		}
+	}
+	if err != nil {
+		handler.logger.Error("Error connecting to trans: %s\n", err.Error())
+		return []domain.TransField{}, upstreamError(transUnavailable, "Error connecting with trans server")
+	}
+	defer handler.backends.release(backend)
+	defer conn.Close() //nolint: errcheck, megacheck
+	if ctx.Err() != nil {
+		return []domain.TransField{}, contextError(ctx)
+	}
+
+	resp, err := handler.sendWithContext(ctx, conn, buf)
+	switch err.(type) {
+	case nil:
+		handler.backends.success(backend)
+	case domain.BusyError:
+		handler.backends.failure(backend)
+	default:
+		// the backend isn't to blame if the caller went away
+		if ctx.Err() != context.Canceled {
+			handler.backends.breakerFailure(backend)
+		}
+	}
+	return resp, err
 }
 
 // isAllowedCommand checks if the given command can be sent to trans
@@ -101,31 +365,78 @@ func (handler *trans) isAllowedCommand(cmd string) bool {
 	return false
 }
 
-// connect returns a connection to the trans client.
-// Retries to connect after retryAfter time if the connection times out
-func (handler *trans) connect() (net.Conn, error) {
-	// initiate the retrier that will handle retry reconnect if the connection dies
-	r := retrier.New(
-		[]time.Duration{
-			time.Duration(handler.conf.RetryAfter) * time.Second},
-		nil,
-	)
-	var conn net.Conn
-	// set the function that starts the connection
-	err := r.Run(func() error {
-		var e error
-		conn, e = net.DialTimeout(
-			"tcp",
-			fmt.Sprintf(
-				"%s:%d",
-				handler.conf.Host,
-				handler.conf.Port,
-			),
-			time.Duration(handler.conf.Timeout)*time.Second,
-		)
-		return e
-	})
-	return conn, err
+// isDryRunCommand checks if the given command can be sent without committing it
+func (handler *trans) isDryRunCommand(cmd string) bool {
+	for _, dryRunCommand := range handler.dryRunCommands {
+		if dryRunCommand == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// connect returns a connection to one of the trans backends, along with the
+// backend, that must be released once the command is done. When a backend
+// can't be reached, the next one is tried right away. If the circuit breakers
+// of every backend are open, errBreakerOpen is returned. Backends
+// aren't blamed for connections abandoned because ctx is done. The backends
+// on used are skipped, and the ones picked are added to it
+func (handler *trans) connect(ctx context.Context, used *transBackendSet) (net.Conn, *transBackend, error) {
+	tried := used.copy()
+	backend := handler.backends.pick(tried)
+	if backend == nil {
+		return nil, nil, errBreakerOpen
+	}
+	var err error
+	for ; backend != nil; backend = handler.backends.pick(tried) {
+		used.add(backend)
+		var conn net.Conn
+		conn, err = handler.dial(ctx, backend.address)
+		if err == nil {
+			return conn, backend, nil
+		}
+		if ctx.Err() != nil {
+			handler.backends.release(backend)
+			return nil, nil, err
+		}
+		// the failure is counted before the backend is released, so no other
+		// command checks a half-open backend in between
+		handler.backends.failure(backend)
+		handler.backends.breakerFailure(backend)
+		handler.backends.release(backend)
+		tried[backend] = true
+	}
+	return nil, nil, err
+}
+
+// dial opens a connection to the trans at endpoint, waiting up to DialTimeout.
+// With TLS enabled, the handshake is limited to DialTimeout too
+func (handler *trans) dial(ctx context.Context, endpoint string) (net.Conn, error) {
+	network, address, err := parseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	dialer := net.Dialer{Timeout: handler.conf.DialTimeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil || handler.tls == nil {
+		return conn, err
+	}
+	// unix sockets have no host, so the server name must be set to verify trans
+	host := ""
+	if network == "tcp" {
+		host, _, _ = net.SplitHostPort(address)
+	}
+	tlsConn := tls.Client(conn, handler.tls.clientConfig(host))
+	if handler.conf.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, handler.conf.DialTimeout)
+		defer cancel()
+	}
+	if err = tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close() //nolint: errcheck, megacheck
+		return nil, err
+	}
+	return tlsConn, nil
 }
 
 // sendWithContext sends the message to trans but is cancelable via a context.
@@ -134,10 +445,9 @@ func (handler *trans) connect() (net.Conn, error) {
 func (handler *trans) sendWithContext(
 	ctx context.Context,
 	conn io.ReadWriteCloser,
-	cmd string,
-	args []domain.TransParams,
-) (map[string]string, error) {
-	var resp map[string]string
+	buf []byte,
+) ([]domain.TransField, error) {
+	var resp []domain.TransField
 	errChan := make(chan error, 1)
 
 	// starts the go routine that sends the message and retrieves the response and error, if any.
@@ -145,7 +455,7 @@ func (handler *trans) sendWithContext(
 	go func() {
 		errChan <- func() error {
 			var err error
-			resp, err = handler.send(conn, cmd, args)
+			resp, err = handler.send(conn, buf)
 			return err
 		}()
 	}()
@@ -160,8 +470,8 @@ func (handler *trans) sendWithContext(
 		}
 		// wait for the goroutine to return and ignore the error
 		<-errChan
-		// return the context error: the operation timed out.
-		return nil, ctx.Err()
+		// the operation timed out or was canceled.
+		return nil, contextError(ctx)
 	case err := <-errChan:
 		// in this case the send function returned before
 		// the timeout of the context.
@@ -169,78 +479,59 @@ func (handler *trans) sendWithContext(
 	}
 }
 
-func (handler *trans) send(conn io.ReadWriter, cmd string, args []domain.TransParams) (map[string]string, error) {
+// send writes the encoded command to trans and reads its response. The
+// greeting must arrive within GreetingTimeout, and the response within
+// ReadTimeout, if set
+func (handler *trans) send(conn io.ReadWriter, buf []byte) ([]domain.TransField, error) {
 	// Check greeting.
+	setReadDeadline(conn, handler.conf.GreetingTimeout)
 	reader := bufio.NewReader(conn)
 	line, err := reader.ReadSlice('\n')
 	if err != nil {
-		return nil, err
+		return nil, readError(err.Error(), err)
+	}
+	if bytes.HasPrefix(line, []byte("521 ")) {
+		return nil, domain.BusyError{
+			RetryAfter: time.Duration(handler.conf.BusyRetryAfter) * time.Second,
+		}
 	}
 	if !bytes.Equal(line, []byte("220 Welcome.\n")) {
-		return nil, fmt.Errorf("trans: unexpected greeting: %q", line)
+		return nil, upstreamError(transBadResponse, fmt.Sprintf("trans: unexpected greeting: %q", line))
 	}
 
-	buf := make([]byte, 0)
 	// Send command to Trans.
-	buf = appendCmd(buf, cmd, args)
 	if _, err = conn.Write(buf); err != nil {
-		return nil, err
+		return nil, upstreamError(transConnectionLost, err.Error())
 	}
 
-	var buffer bytes.Buffer
-	_, err = buffer.ReadFrom(reader)
+	setReadDeadline(conn, handler.conf.ReadTimeout)
+	fields, err := newTransReader(reader, handler.conf).readFields()
 	if err != nil {
-		return nil, err
+		return nil, readError(fmt.Sprintf("error parsing response: %s", err.Error()), err)
 	}
+	if err = handler.decodeFields(fields); err != nil {
+		return nil, upstreamError(transBadResponse, fmt.Sprintf("error decoding response: %s", err.Error()))
+	}
+	return fields, nil
+}
 
-	buf, encodingErr := charmap.ISO8859_1.NewDecoder().Bytes(bytes.TrimSuffix(buffer.Bytes(), []byte("end\n")))
-	if encodingErr != nil {
-		handler.logger.Debug("Latin 1 expected, encoding error: %s\n", encodingErr.Error())
-	}
-	respMap, err := TransResponse(buf).Map()
-	if err != nil {
-		return respMap, fmt.Errorf("error parsing response: %s", err.Error())
-	}
-	return respMap, nil
-}
-
-// appendCmd Appends the command to the buffer. For the command format, see:
-// https://scmcoord.com/wiki/Trans#Protocol
-func appendCmd(buf []byte, cmd string, args []domain.TransParams) []byte {
-	buf = append(buf, "cmd:"...)
-	buf = append(buf, cmd...)
-	buf = append(buf, '\n')
-	for _, param := range args {
-		key := param.Key
-		if value, ok := param.Value.(string); ok {
-			if param.Blob {
-				if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
-					value = string(decoded)
-					buf = append(buf, "blob:"...)
-					buf = strconv.AppendInt(buf, int64(len(value)), 10)
-					buf = append(buf, ':')
-					buf = append(buf, key...)
-					buf = append(buf, '\n')
-					buf = append(buf, value...)
-					buf = append(buf, '\n')
-				}
-				continue
-			}
-			key, err := charmap.ISO8859_1.NewEncoder().String(key)
-			if err != nil {
-				continue
-			}
-			value, err = charmap.ISO8859_1.NewEncoder().String(value)
-			if err != nil {
-				continue
-			}
-			buf = append(buf, key...)
-			buf = append(buf, ':')
-			buf = append(buf, value...)
-			buf = append(buf, '\n')
-		}
-	}
-	buf = append(buf, "commit:1"...)
-	buf = append(buf, "\nend\n"...)
-	return buf
+// decodeFields decodes the keys and the text values of the fields from the
+// trans charset. Blob values are binary data, so they are kept as they were received
+func (handler *trans) decodeFields(fields []domain.TransField) error {
+	for i := range fields {
+		key, err := handler.charset.decode(fields[i].Key)
+		if err != nil {
+			return err
+		}
+		fields[i].Key = key
+		if fields[i].Blob {
+			continue
+		}
+		value, err := handler.charset.decode(fields[i].Value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", key, err.Error())
+		}
+		fields[i].Value = value
+	}
+	return nil
 }
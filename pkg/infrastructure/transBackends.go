@@ -0,0 +1,322 @@
+package infrastructure
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/loggers"
+)
+
+// Balancers that can be used to pick a backend among the ones of the same priority
+const (
+	// roundRobin picks the backends in turns, proportionally to their weight
+	roundRobin = "round_robin"
+	// leastInflight picks the backend with less commands in flight for its weight
+	leastInflight = "least_inflight"
+)
+
+// transBackend is a trans server commands can be sent to
+type transBackend struct {
+	// address the endpoint of the backend: host:port, tcp://host:port or unix:///path/to/sock
+	address string
+	// weight how many commands the backend gets in relation to the others
+	weight int
+	// priority the group of the backend. Lower priorities are used first
+	priority int
+	// breaker fails fast while the backend keeps failing
+	breaker *transBreaker
+
+	// the fields below are guarded by the mutex of the pool
+	current  int
+	inflight int
+	failures int
+	ejected  bool
+	probing  bool
+	probeAt  time.Time
+}
+
+// parseTransBackends reads the backends on conf. Each backend is written as
+// endpoint[,weight[,priority]], and they are separated by '|'. If there are
+// none, the trans on Endpoint, or else on Host and Port, is the only backend
+func parseTransBackends(conf TransConf) ([]*transBackend, error) {
+	if conf.Backends == "" {
+		address := conf.Endpoint
+		if address == "" {
+			address = fmt.Sprintf("%s:%d", conf.Host, conf.Port)
+		}
+		if _, _, err := parseEndpoint(address); err != nil {
+			return nil, err
+		}
+		return []*transBackend{{address: address, weight: 1}}, nil
+	}
+	var backends []*transBackend
+	for _, entry := range strings.Split(conf.Backends, "|") {
+		parts := strings.Split(strings.TrimSpace(entry), ",")
+		if len(parts) > 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid trans backend %q", entry)
+		}
+		if _, _, err := parseEndpoint(parts[0]); err != nil {
+			return nil, err
+		}
+		backend := &transBackend{address: parts[0], weight: 1}
+		if len(parts) > 1 {
+			weight, err := strconv.Atoi(parts[1])
+			if err != nil || weight < 1 {
+				return nil, fmt.Errorf("invalid weight on trans backend %q", entry)
+			}
+			backend.weight = weight
+		}
+		if len(parts) > 2 {
+			priority, err := strconv.Atoi(parts[2])
+			if err != nil || priority < 0 {
+				return nil, fmt.Errorf("invalid priority on trans backend %q", entry)
+			}
+			backend.priority = priority
+		}
+		backends = append(backends, backend)
+	}
+	return backends, nil
+}
+
+// parseEndpoint returns the network and the address to dial for an endpoint,
+// written as tcp://host:port or unix:///path/to/sock. Endpoints without a
+// scheme are host:port
+func parseEndpoint(endpoint string) (string, string, error) {
+	network, address := "tcp", endpoint
+	if i := strings.Index(endpoint, "://"); i >= 0 {
+		network, address = endpoint[:i], endpoint[i+3:]
+	}
+	switch network {
+	case "tcp":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("invalid trans endpoint %q: %s", endpoint, err)
+		}
+	case "unix":
+		if address == "" {
+			return "", "", fmt.Errorf("invalid trans endpoint %q: missing socket path", endpoint)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid trans endpoint %q: unknown network %s", endpoint, network)
+	}
+	return network, address, nil
+}
+
+// transBackendPool picks the backend each command is sent to. Only the
+// backends with the lowest priority among the healthy ones are used. Backends
+// that fail ejectAfter times in a row, either connecting or being busy, are
+// ejected until a transinfo probe finds them healthy again. Backends whose
+// circuit breaker is open are never used
+type transBackendPool struct {
+	mutex         sync.Mutex
+	backends      []*transBackend
+	leastInflight bool
+	ejectAfter    int
+	probeInterval time.Duration
+	probe         func(address string) error
+	logger        loggers.Logger
+	metrics       *TransCollector
+}
+
+// newTransBackendPool creates a pool for the backends on conf, that probes
+// the ejected backends with the given function. Changes on the circuit
+// breakers are reported to metrics
+func newTransBackendPool(
+	conf TransConf,
+	logger loggers.Logger,
+	metrics *TransCollector,
+	probe func(address string) error,
+) (*transBackendPool, error) {
+	backends, err := parseTransBackends(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.Balancer != "" && conf.Balancer != roundRobin && conf.Balancer != leastInflight {
+		return nil, fmt.Errorf("unknown trans balancer %q", conf.Balancer)
+	}
+	pool := &transBackendPool{
+		backends:      backends,
+		leastInflight: conf.Balancer == leastInflight,
+		ejectAfter:    conf.EjectAfter,
+		probeInterval: conf.ProbeInterval,
+		probe:         probe,
+		logger:        logger,
+		metrics:       metrics,
+	}
+	for _, backend := range backends {
+		backend := backend
+		backend.breaker = newTransBreaker(conf, func(state breakerState) {
+			pool.breakerChanged(backend, state)
+		})
+	}
+	return pool, nil
+}
+
+// pick returns the backend the next command should be sent to, skipping the
+// ones in exclude and the ones whose breaker is open. Ejected backends are
+// only picked when no other is left. The backend must be released once the command is done
+func (p *transBackendPool) pick(exclude map[*transBackend]bool) *transBackend {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.startProbes()
+	now := time.Now()
+	var healthy, ejected []*transBackend
+	for _, backend := range p.backends {
+		if exclude[backend] || !backend.breaker.allow(now) {
+			continue
+		}
+		if backend.ejected {
+			ejected = append(ejected, backend)
+		} else {
+			healthy = append(healthy, backend)
+		}
+	}
+	candidates := healthy
+	if len(candidates) == 0 {
+		candidates = ejected
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	candidates = lowestPriority(candidates)
+	var backend *transBackend
+	if p.leastInflight {
+		backend = pickLeastInflight(candidates)
+	} else {
+		backend = pickRoundRobin(candidates)
+	}
+	backend.inflight++
+	backend.breaker.take()
+	return backend
+}
+
+// size returns how many backends are on the pool
+func (p *transBackendPool) size() int {
+	return len(p.backends)
+}
+
+// lowestPriority returns the backends of the lowest priority group
+func lowestPriority(backends []*transBackend) []*transBackend {
+	priority := backends[0].priority
+	for _, backend := range backends {
+		if backend.priority < priority {
+			priority = backend.priority
+		}
+	}
+	group := make([]*transBackend, 0, len(backends))
+	for _, backend := range backends {
+		if backend.priority == priority {
+			group = append(group, backend)
+		}
+	}
+	return group
+}
+
+// pickRoundRobin picks the backends in turns using a smooth weighted round
+// robin, so heavier backends are picked more often but not in a row
+func pickRoundRobin(backends []*transBackend) *transBackend {
+	var picked *transBackend
+	total := 0
+	for _, backend := range backends {
+		backend.current += backend.weight
+		total += backend.weight
+		if picked == nil || backend.current > picked.current {
+			picked = backend
+		}
+	}
+	picked.current -= total
+	return picked
+}
+
+// pickLeastInflight picks the backend with less commands in flight relative
+// to its weight. Ties go to the first backend
+func pickLeastInflight(backends []*transBackend) *transBackend {
+	picked := backends[0]
+	for _, backend := range backends[1:] {
+		if backend.inflight*picked.weight < picked.inflight*backend.weight {
+			picked = backend
+		}
+	}
+	return picked
+}
+
+// release tells the pool a command sent to the backend is done
+func (p *transBackendPool) release(backend *transBackend) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	backend.inflight--
+	backend.breaker.done()
+}
+
+// success resets the failures of the backend, restoring it if it was ejected
+func (p *transBackendPool) success(backend *transBackend) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	backend.breaker.success(time.Now())
+	backend.failures = 0
+	if backend.ejected {
+		backend.ejected = false
+		p.logger.Info("Trans backend %s restored\n", backend.address)
+	}
+}
+
+// failure counts a failure of the backend, ejecting it once it failed
+// ejectAfter times in a row. Nothing is ejected if ejectAfter is 0
+func (p *transBackendPool) failure(backend *transBackend) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	backend.failures++
+	if p.ejectAfter == 0 || backend.failures < p.ejectAfter || backend.ejected {
+		return
+	}
+	backend.ejected = true
+	backend.probeAt = time.Now().Add(p.probeInterval)
+	p.logger.Warn("Trans backend %s ejected after %d failures\n", backend.address, backend.failures)
+}
+
+// breakerFailure counts a failure on the circuit breaker of the backend
+func (p *transBackendPool) breakerFailure(backend *transBackend) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	backend.breaker.failure(time.Now())
+}
+
+// breakerChanged reports a change on the circuit breaker of the backend. The mutex must be held
+func (p *transBackendPool) breakerChanged(backend *transBackend, state breakerState) {
+	if state == breakerOpen {
+		p.logger.Warn("Trans backend %s circuit breaker open\n", backend.address)
+	} else {
+		p.logger.Info("Trans backend %s circuit breaker %s\n", backend.address, state)
+	}
+	p.metrics.CollectBreakerState(backend.address, state)
+}
+
+// startProbes probes the ejected backends that are due. The mutex must be held
+func (p *transBackendPool) startProbes() {
+	now := time.Now()
+	for _, backend := range p.backends {
+		if backend.ejected && !backend.probing && !now.Before(backend.probeAt) {
+			backend.probing = true
+			go p.runProbe(backend)
+		}
+	}
+}
+
+// runProbe restores the backend if the probe succeeds, or schedules the next one
+func (p *transBackendPool) runProbe(backend *transBackend) {
+	err := p.probe(backend.address)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	backend.probing = false
+	if err != nil {
+		backend.probeAt = time.Now().Add(p.probeInterval)
+		p.logger.Debug("Trans backend %s probe failed: %s\n", backend.address, err)
+		return
+	}
+	backend.ejected = false
+	backend.failures = 0
+	p.logger.Info("Trans backend %s restored\n", backend.address)
+}
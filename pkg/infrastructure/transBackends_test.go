@@ -0,0 +1,242 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// closedAddress returns an address where nothing is listening
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	address := listener.Addr().String()
+	assert.NoError(t, listener.Close())
+	return address
+}
+
+func TestParseTransBackendsDefault(t *testing.T) {
+	backends, err := parseTransBackends(TransConf{Host: "localhost", Port: 20005})
+	assert.NoError(t, err)
+	assert.Equal(t, []*transBackend{{address: "localhost:20005", weight: 1}}, backends)
+}
+
+func TestParseTransBackendsEndpoint(t *testing.T) {
+	backends, err := parseTransBackends(TransConf{Host: "localhost", Port: 20005, Endpoint: "unix:///run/trans.sock"})
+	assert.NoError(t, err)
+	assert.Equal(t, []*transBackend{{address: "unix:///run/trans.sock", weight: 1}}, backends)
+}
+
+func TestParseEndpoint(t *testing.T) {
+	for endpoint, expected := range map[string][2]string{
+		"trans:5656":             {"tcp", "trans:5656"},
+		"tcp://trans:5656":       {"tcp", "trans:5656"},
+		"unix:///run/trans.sock": {"unix", "/run/trans.sock"},
+	} {
+		network, address, err := parseEndpoint(endpoint)
+		assert.NoError(t, err, endpoint)
+		assert.Equal(t, expected, [2]string{network, address}, endpoint)
+	}
+	for _, endpoint := range []string{"trans", "tcp://trans", "unix://", "udp://trans:5656"} {
+		_, _, err := parseEndpoint(endpoint)
+		assert.Error(t, err, endpoint)
+	}
+}
+
+func TestParseTransBackends(t *testing.T) {
+	backends, err := parseTransBackends(TransConf{
+		Backends: "trans1:5656,3|tcp://trans2:5656| trans3:5656,1,1|unix:///run/trans.sock,1,2",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []*transBackend{
+		{address: "trans1:5656", weight: 3},
+		{address: "tcp://trans2:5656", weight: 1},
+		{address: "trans3:5656", weight: 1, priority: 1},
+		{address: "unix:///run/trans.sock", weight: 1, priority: 2},
+	}, backends)
+}
+
+func TestParseTransBackendsInvalid(t *testing.T) {
+	for _, backends := range []string{"trans1:5656|", "trans1:5656,0", "trans1:5656,1,-1", "trans1:5656,1,1,1", "udp://trans1:5656"} {
+		_, err := parseTransBackends(TransConf{Backends: backends})
+		assert.Error(t, err, backends)
+	}
+}
+
+func TestNewTransBackendPoolInvalidBalancer(t *testing.T) {
+	_, err := newTransBackendPool(TransConf{Balancer: "random"}, &MockLoggerInfrastructure{}, nil, nil)
+	assert.EqualError(t, err, "unknown trans balancer \"random\"")
+}
+
+func TestTransBackendPoolRoundRobin(t *testing.T) {
+	pool, err := newTransBackendPool(TransConf{Backends: "a:1,2|b:1"}, &MockLoggerInfrastructure{}, nil, nil)
+	assert.NoError(t, err)
+	var picked []string
+	for i := 0; i < 6; i++ {
+		backend := pool.pick(nil)
+		picked = append(picked, backend.address)
+		pool.release(backend)
+	}
+	assert.Equal(t, []string{"a:1", "b:1", "a:1", "a:1", "b:1", "a:1"}, picked)
+}
+
+func TestTransBackendPoolLeastInflight(t *testing.T) {
+	conf := TransConf{Backends: "a:1|b:1", Balancer: leastInflight}
+	pool, err := newTransBackendPool(conf, &MockLoggerInfrastructure{}, nil, nil)
+	assert.NoError(t, err)
+	first := pool.pick(nil)
+	second := pool.pick(nil)
+	assert.Equal(t, "a:1", first.address)
+	assert.Equal(t, "b:1", second.address)
+	pool.release(first)
+	assert.Equal(t, "a:1", pool.pick(nil).address)
+}
+
+func TestTransBackendPoolPriorityFailover(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Warn")
+	conf := TransConf{Backends: "primary:1|secondary:1,1,1", EjectAfter: 2, ProbeInterval: 60 * time.Second}
+	pool, err := newTransBackendPool(conf, &logger, nil, nil)
+	assert.NoError(t, err)
+
+	primary := pool.pick(nil)
+	assert.Equal(t, "primary:1", primary.address)
+	pool.release(primary)
+	pool.failure(primary)
+	assert.Equal(t, primary, pool.pick(nil))
+	pool.release(primary)
+	pool.failure(primary)
+	assert.Equal(t, "secondary:1", pool.pick(nil).address)
+	// with every other backend excluded, the ejected one is used anyway
+	assert.Equal(t, primary, pool.pick(map[*transBackend]bool{pool.backends[1]: true}))
+	logger.AssertExpectations(t)
+}
+
+func TestTransBackendPoolProbeRestores(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Warn")
+	logger.On("Debug")
+	logger.On("Info")
+	probes := make(chan string, 2)
+	healthy := errors.New("unhealthy")
+	probe := func(address string) error {
+		probes <- address
+		err := healthy
+		healthy = nil
+		return err
+	}
+	conf := TransConf{Backends: "a:1|b:1", EjectAfter: 1}
+	pool, err := newTransBackendPool(conf, &logger, nil, probe)
+	assert.NoError(t, err)
+
+	pool.failure(pool.backends[0])
+	assert.True(t, pool.backends[0].ejected)
+	// the first probe fails, so the backend is probed again
+	pool.release(pool.pick(nil))
+	assert.Equal(t, "a:1", <-probes)
+	assert.Eventually(t, func() bool {
+		pool.release(pool.pick(nil))
+		pool.mutex.Lock()
+		defer pool.mutex.Unlock()
+		return !pool.backends[0].ejected
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "a:1", <-probes)
+}
+
+func TestSendCommandBackendFailover(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+	logger := MockLoggerInfrastructure{}
+	logger.On("Warn")
+	conf := TransConf{
+		Backends:        closedAddress(t) + "|" + server.Address,
+		EjectAfter:      1,
+		ProbeInterval:   60 * time.Second,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		resp, err := transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+		assert.NoError(t, err)
+		assert.Equal(t, []domain.TransField{{Key: "status", Value: "TRANS_OK"}}, resp)
+	}
+	logger.AssertExpectations(t)
+}
+
+func TestTransFactoryProbe(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		assert.Equal(t, "cmd:transinfo\ncommit:1\nend\n", string(input))
+		return []byte("status:TRANS_OK\n")
+	})
+	transFactory, err := NewTextProtocolTransFactory(TransConf{Timeout: 15}, &MockLoggerInfrastructure{}, nil)
+	assert.NoError(t, err)
+	factory := transFactory.(*textProtocolTransFactory)
+
+	assert.NoError(t, factory.probe(server.Address))
+	assert.Error(t, factory.probe(closedAddress(t)))
+}
+
+func TestSendCommandUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	server := NewMockUnixTransServer(filepath.Join(dir, "trans.sock"))
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+	assert.Equal(t, "unix://"+filepath.Join(dir, "trans.sock"), server.Address)
+
+	conf := TransConf{Endpoint: server.Address, Timeout: 15, AllowedCommands: "transinfo"}
+	transFactory, err := NewTextProtocolTransFactory(conf, &MockLoggerInfrastructure{}, nil)
+	assert.NoError(t, err)
+	resp, err := transFactory.MakeTransHandler().SendCommand(
+		context.Background(),
+		domain.TransCommand{Command: "transinfo"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}}, resp)
+}
+
+func TestSendCommandBreakerOpen(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	logger.On("Warn")
+	logger.On("Debug")
+	conf := TransConf{
+		Backends:        closedAddress(t),
+		BreakerErrors:   2,
+		BreakerTimeout:  60 * time.Second,
+		RetryAttempts:   1,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	// the connection and its retry fail, opening the breaker
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.Equal(t, domain.ErrorClassUpstream, err.(domain.TransError).Class)
+
+	start := time.Now()
+	_, err = transFactory.MakeTransHandler().SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.Equal(t, domain.ErrorClassUnavailable, err.(domain.TransError).Class)
+	assert.True(t, time.Since(start) < time.Second)
+	logger.AssertExpectations(t)
+}
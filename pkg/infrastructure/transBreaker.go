@@ -0,0 +1,134 @@
+package infrastructure
+
+import (
+	"errors"
+	"time"
+)
+
+// errBreakerOpen is returned when the circuit breakers of every backend are open
+var errBreakerOpen = errors.New("every trans backend circuit breaker is open") // nolint: gochecknoglobals
+
+// breakerState is the state of a transBreaker
+type breakerState int
+
+const (
+	// breakerClosed commands go through
+	breakerClosed breakerState = iota
+	// breakerHalfOpen commands go through to check if the backend recovered
+	breakerHalfOpen
+	// breakerOpen commands fail fast without reaching the backend
+	breakerOpen
+)
+
+// String returns the name of the state
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half_open"
+	case breakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// transBreaker is a circuit breaker that opens after errorThreshold failures
+// in a row, half-opens once timeout has passed, and closes after
+// successThreshold successes in a row, while a single failure opens it again.
+// While half-open, a single command at a time checks if the backend
+// recovered. Every change of state is reported to onChange. It isn't safe for
+// concurrent use, the pool guards it
+type transBreaker struct {
+	errorThreshold   int
+	successThreshold int
+	timeout          time.Duration
+	onChange         func(breakerState)
+
+	state     breakerState
+	errors    int
+	successes int
+	openedAt  time.Time
+	// trial a command is checking if the backend recovered
+	trial bool
+}
+
+// newTransBreaker creates a closed breaker configured from conf. If
+// BreakerErrors is 0 the breaker never opens
+func newTransBreaker(conf TransConf, onChange func(breakerState)) *transBreaker {
+	return &transBreaker{
+		errorThreshold:   conf.BreakerErrors,
+		successThreshold: conf.BreakerSuccesses,
+		timeout:          conf.BreakerTimeout,
+		onChange:         onChange,
+	}
+}
+
+// allow tells if a command can be sent. An open breaker half-opens here once
+// its timeout has passed. A half-open breaker allows no command while another
+// one is checking the backend
+func (b *transBreaker) allow(now time.Time) bool {
+	switch b.state {
+	case breakerClosed:
+		return true
+	case breakerHalfOpen:
+		return !b.trial
+	}
+	if now.Sub(b.openedAt) < b.timeout {
+		return false
+	}
+	b.setState(breakerHalfOpen, now)
+	return true
+}
+
+// take counts a command sent to the backend. On a half-open breaker, it's
+// the one checking the backend until done is called
+func (b *transBreaker) take() {
+	if b.state == breakerHalfOpen {
+		b.trial = true
+	}
+}
+
+// done counts a command that is no longer in flight
+func (b *transBreaker) done() {
+	b.trial = false
+}
+
+// success counts a command that went well
+func (b *transBreaker) success(now time.Time) {
+	switch b.state {
+	case breakerClosed:
+		b.errors = 0
+	case breakerHalfOpen:
+		b.successes++
+		if b.successes >= b.successThreshold {
+			b.setState(breakerClosed, now)
+		}
+	}
+}
+
+// failure counts a command that failed
+func (b *transBreaker) failure(now time.Time) {
+	switch b.state {
+	case breakerClosed:
+		b.errors++
+		if b.errorThreshold > 0 && b.errors >= b.errorThreshold {
+			b.setState(breakerOpen, now)
+		}
+	case breakerHalfOpen:
+		b.setState(breakerOpen, now)
+	}
+}
+
+// setState moves the breaker to state, starting its counters over
+func (b *transBreaker) setState(state breakerState, now time.Time) {
+	b.state = state
+	b.errors = 0
+	b.successes = 0
+	b.trial = false
+	if state == breakerOpen {
+		b.openedAt = now
+	}
+	if b.onChange != nil {
+		b.onChange(state)
+	}
+}
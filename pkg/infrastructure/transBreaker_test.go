@@ -0,0 +1,76 @@
+package infrastructure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransBreakerOpensAndCloses(t *testing.T) {
+	var states []breakerState
+	conf := TransConf{BreakerErrors: 2, BreakerSuccesses: 2, BreakerTimeout: 10 * time.Second}
+	b := newTransBreaker(conf, func(state breakerState) {
+		states = append(states, state)
+	})
+	now := time.Now()
+
+	b.failure(now)
+	b.success(now)
+	b.failure(now)
+	assert.True(t, b.allow(now))
+	b.failure(now)
+	assert.False(t, b.allow(now.Add(9*time.Second)))
+	assert.True(t, b.allow(now.Add(10*time.Second)))
+	b.success(now)
+	b.success(now)
+	assert.Equal(t, []breakerState{breakerOpen, breakerHalfOpen, breakerClosed}, states)
+}
+
+func TestTransBreakerHalfOpenFailure(t *testing.T) {
+	b := newTransBreaker(TransConf{BreakerErrors: 1, BreakerSuccesses: 1, BreakerTimeout: 10 * time.Second}, nil)
+	now := time.Now()
+
+	b.failure(now)
+	assert.True(t, b.allow(now.Add(10*time.Second)))
+	assert.Equal(t, breakerHalfOpen, b.state)
+	b.failure(now.Add(10 * time.Second))
+	assert.Equal(t, breakerOpen, b.state)
+	assert.False(t, b.allow(now.Add(19*time.Second)))
+}
+
+func TestTransBreakerDisabled(t *testing.T) {
+	b := newTransBreaker(TransConf{}, nil)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		b.failure(now)
+	}
+	assert.True(t, b.allow(now))
+}
+
+func TestBreakerStateString(t *testing.T) {
+	assert.Equal(t, "closed", breakerClosed.String())
+	assert.Equal(t, "half_open", breakerHalfOpen.String())
+	assert.Equal(t, "open", breakerOpen.String())
+}
+
+func TestTransBreakerHalfOpenSingleTrial(t *testing.T) {
+	b := newTransBreaker(TransConf{BreakerErrors: 1, BreakerSuccesses: 2, BreakerTimeout: 10 * time.Second}, nil)
+	now := time.Now().Add(10 * time.Second)
+
+	b.failure(now.Add(-10 * time.Second))
+	assert.True(t, b.allow(now))
+	b.take()
+	// only one command at a time checks the half-open backend
+	assert.False(t, b.allow(now))
+	b.success(now)
+	b.done()
+	assert.True(t, b.allow(now))
+	b.take()
+	b.success(now)
+	b.done()
+	assert.Equal(t, breakerClosed, b.state)
+	// closed breakers let every command through
+	b.take()
+	assert.True(t, b.allow(now))
+}
@@ -0,0 +1,280 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// Codes of the commands rejected by the bulkhead
+const (
+	// transQueueFull too many commands were already waiting for a slot
+	transQueueFull = "TRANS_QUEUE_FULL"
+	// transQueueTimeout the command waited too long for a slot
+	transQueueTimeout = "TRANS_QUEUE_TIMEOUT"
+)
+
+// transWaiter is a command waiting for a slot. ready gets true once the
+// slot is handed over, or false if the command is shed
+type transWaiter struct {
+	cmd   string
+	ready chan bool
+}
+
+// transLane queues the commands of a priority
+type transLane struct {
+	priority domain.Priority
+	// weight how many commands of the lane get a slot in relation to the other lanes
+	weight  int
+	current int
+	waiters []*transWaiter
+}
+
+// transBulkhead caps how many commands are sent to trans at the same time,
+// overall and for each command. Commands over the limits wait for a slot, up
+// to queueTimeout, as long as less than queueSize commands are waiting
+// already. Each priority waits on its own lane, and freed slots are handed
+// over to the lanes in turns, proportionally to their weight. When the queue
+// is full, batch commands are shed to make room for interactive ones
+type transBulkhead struct {
+	maxConcurrency int
+	limits         map[string]int
+	queueSize      int
+	queueTimeout   time.Duration
+	metrics        *TransCollector
+
+	mutex    sync.Mutex
+	inflight int
+	commands map[string]int
+	// lanes from the most to the least important
+	lanes   []*transLane
+	waiting int
+}
+
+// newTransBulkhead creates the bulkhead configured on conf. A limit of 0 means no limit
+func newTransBulkhead(conf TransConf, metrics *TransCollector) (*transBulkhead, error) {
+	limits, err := parseIntList(conf.CommandConcurrency, "command concurrency")
+	if err != nil {
+		return nil, err
+	}
+	weights, err := parseIntList(conf.PriorityWeights, "priority weight")
+	if err != nil {
+		return nil, err
+	}
+	bulkhead := &transBulkhead{
+		maxConcurrency: conf.MaxConcurrency,
+		limits:         limits,
+		queueSize:      conf.QueueSize,
+		queueTimeout:   conf.QueueTimeout,
+		metrics:        metrics,
+		commands:       make(map[string]int),
+	}
+	for _, priority := range []domain.Priority{domain.PriorityInteractive, domain.PriorityBatch} {
+		lane := &transLane{priority: priority, weight: 1}
+		if weight, ok := weights[string(priority)]; ok {
+			lane.weight = weight
+			delete(weights, string(priority))
+		}
+		bulkhead.lanes = append(bulkhead.lanes, lane)
+	}
+	if len(weights) > 0 {
+		return nil, fmt.Errorf("unknown priorities on priority weights %q", conf.PriorityWeights)
+	}
+	return bulkhead, nil
+}
+
+// parseIntList reads a list of positive numbers by name, written as
+// name=number and separated by '|', like imgput=2|newad=10
+func parseIntList(list, what string) (map[string]int, error) {
+	values := make(map[string]int)
+	for _, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s %q", what, entry)
+		}
+		value, err := strconv.Atoi(parts[1])
+		if err != nil || value < 1 {
+			return nil, fmt.Errorf("invalid %s %q", what, entry)
+		}
+		values[parts[0]] = value
+	}
+	return values, nil
+}
+
+// acquire takes a slot for the command, waiting for it on the lane of its
+// priority if needed. The returned function gives the slot back and must be
+// called once the command is done
+func (b *transBulkhead) acquire(ctx context.Context, cmd string, priority domain.Priority) (func(), error) {
+	release := func() { b.release(cmd) }
+	b.mutex.Lock()
+	if b.free(cmd) {
+		b.take(cmd)
+		b.mutex.Unlock()
+		return release, nil
+	}
+	lane := b.lane(priority)
+	if b.waiting >= b.queueSize && !b.shed(lane) {
+		b.mutex.Unlock()
+		b.metrics.CollectRejected(cmd, transQueueFull)
+		return nil, queueFullError("too many commands waiting for trans")
+	}
+	waiter := &transWaiter{cmd: cmd, ready: make(chan bool, 1)}
+	lane.waiters = append(lane.waiters, waiter)
+	b.waiting++
+	b.metrics.CollectQueueDepth(cmd, string(lane.priority), 1)
+	b.mutex.Unlock()
+
+	queuedAt := time.Now()
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case ok := <-waiter.ready:
+		b.metrics.CollectQueueWait(cmd, string(lane.priority), time.Since(queuedAt))
+		if !ok {
+			return nil, queueFullError("shed to make room for more important commands")
+		}
+		return release, nil
+	case <-timer.C:
+		b.metrics.CollectRejected(cmd, transQueueTimeout)
+		err = domain.TransError{
+			Class:   domain.ErrorClassUnavailable,
+			Code:    transQueueTimeout,
+			Message: fmt.Sprintf("no trans connection was free after %s", b.queueTimeout),
+		}
+	case <-ctx.Done():
+		err = contextError(ctx)
+	}
+	if !b.leave(lane, waiter) && <-waiter.ready {
+		// the slot was handed over while giving up on it
+		b.release(cmd)
+	}
+	return nil, err
+}
+
+// tryAcquire takes a slot for the command only if one is free right away and
+// no command is waiting for it. The returned function gives the slot back
+func (b *transBulkhead) tryAcquire(cmd string) (func(), bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.waiting > 0 || !b.free(cmd) {
+		return nil, false
+	}
+	b.take(cmd)
+	return func() { b.release(cmd) }, true
+}
+
+// queueFullError is returned for the commands rejected because the queue is full
+func queueFullError(message string) domain.TransError {
+	return domain.TransError{
+		Class:   domain.ErrorClassOverloaded,
+		Code:    transQueueFull,
+		Message: message,
+	}
+}
+
+// lane returns the lane of the priority. Unknown priorities are interactive
+func (b *transBulkhead) lane(priority domain.Priority) *transLane {
+	for _, lane := range b.lanes {
+		if lane.priority == priority {
+			return lane
+		}
+	}
+	return b.lanes[0]
+}
+
+// free checks if there is a slot for the command. The mutex must be held
+func (b *transBulkhead) free(cmd string) bool {
+	limit, limited := b.limits[cmd]
+	return (b.maxConcurrency == 0 || b.inflight < b.maxConcurrency) &&
+		(!limited || b.commands[cmd] < limit)
+}
+
+// take counts a slot taken by the command. The mutex must be held
+func (b *transBulkhead) take(cmd string) {
+	b.inflight++
+	b.commands[cmd]++
+}
+
+// release gives back the slot of the command, handing over the freed slots to the waiting commands
+func (b *transBulkhead) release(cmd string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.inflight--
+	b.commands[cmd]--
+	for {
+		lane, i := b.next()
+		if lane == nil {
+			return
+		}
+		waiter := lane.waiters[i]
+		b.remove(lane, i)
+		b.take(waiter.cmd)
+		waiter.ready <- true
+	}
+}
+
+// next picks the lane whose waiter gets the next free slot, along with the
+// index of that waiter, using a smooth weighted round robin among the lanes
+// with commands that fit. The mutex must be held
+func (b *transBulkhead) next() (*transLane, int) {
+	var picked *transLane
+	index, total := 0, 0
+	for _, lane := range b.lanes {
+		for i, waiter := range lane.waiters {
+			if !b.free(waiter.cmd) {
+				continue
+			}
+			lane.current += lane.weight
+			total += lane.weight
+			if picked == nil || lane.current > picked.current {
+				picked, index = lane, i
+			}
+			break
+		}
+	}
+	if picked != nil {
+		picked.current -= total
+	}
+	return picked, index
+}
+
+// shed drops the newest waiter of the least important lane below lane, to
+// make room for a command of lane. The mutex must be held
+func (b *transBulkhead) shed(lane *transLane) bool {
+	for i := len(b.lanes) - 1; i >= 0 && b.lanes[i] != lane; i-- {
+		if victim := b.lanes[i]; len(victim.waiters) > 0 {
+			waiter := victim.waiters[len(victim.waiters)-1]
+			b.remove(victim, len(victim.waiters)-1)
+			b.metrics.CollectRejected(waiter.cmd, transQueueFull)
+			waiter.ready <- false
+			return true
+		}
+	}
+	return false
+}
+
+// leave takes the waiter out of the lane, unless it already got a slot or was shed
+func (b *transBulkhead) leave(lane *transLane, waiter *transWaiter) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i := range lane.waiters {
+		if lane.waiters[i] == waiter {
+			b.remove(lane, i)
+			return true
+		}
+	}
+	return false
+}
+
+// remove takes the i-th waiter out of the lane. The mutex must be held
+func (b *transBulkhead) remove(lane *transLane, i int) {
+	b.metrics.CollectQueueDepth(lane.waiters[i].cmd, string(lane.priority), -1)
+	lane.waiters = append(lane.waiters[:i], lane.waiters[i+1:]...)
+	b.waiting--
+}
@@ -0,0 +1,260 @@
+package infrastructure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestParseIntList(t *testing.T) {
+	limits, err := parseIntList("imgput=2|newad=10", "command concurrency")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"imgput": 2, "newad": 10}, limits)
+
+	for _, list := range []string{"imgput", "imgput=0", "imgput=x", "imgput=2|"} {
+		_, err := parseIntList(list, "command concurrency")
+		assert.Error(t, err, list)
+	}
+	_, err = NewTextProtocolTransFactory(TransConf{CommandConcurrency: "imgput"}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestBulkheadUnlimited(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{}, nil)
+	assert.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		_, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+		assert.NoError(t, err)
+	}
+}
+
+func TestBulkheadCommandLimit(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{
+		CommandConcurrency: "imgput=1",
+		QueueSize:          1,
+		QueueTimeout:       time.Second,
+	}, nil)
+	assert.NoError(t, err)
+
+	release, err := bulkhead.acquire(context.Background(), "imgput", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	// other commands aren't limited
+	_, err = bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+
+	// the next imgput waits until the slot is released
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release()
+	}()
+	start := time.Now()
+	release, err = bulkhead.acquire(context.Background(), "imgput", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	release()
+}
+
+func TestBulkheadQueueFull(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{
+		MaxConcurrency: 1,
+		QueueSize:      1,
+		QueueTimeout:   200 * time.Millisecond,
+	}, nil)
+	assert.NoError(t, err)
+
+	release, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	defer release()
+	queued := make(chan error)
+	go func() {
+		_, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+		queued <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+	assert.Equal(t, domain.ErrorClassOverloaded, err.(domain.TransError).Class)
+	assert.Equal(t, transQueueFull, err.(domain.TransError).Code)
+	assert.Error(t, <-queued)
+}
+
+func TestBulkheadQueueTimeout(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{
+		MaxConcurrency: 1,
+		QueueSize:      1,
+		QueueTimeout:   50 * time.Millisecond,
+	}, nil)
+	assert.NoError(t, err)
+
+	release, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	defer release()
+	_, err = bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+	assert.Equal(t, domain.ErrorClassUnavailable, err.(domain.TransError).Class)
+	assert.Equal(t, transQueueTimeout, err.(domain.TransError).Code)
+	assert.Equal(t, 0, bulkhead.waiting)
+}
+
+func TestBulkheadCanceledWhileQueued(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{
+		MaxConcurrency:     1,
+		CommandConcurrency: "newad=2",
+		QueueSize:          1,
+		QueueTimeout:       time.Second,
+	}, nil)
+	assert.NoError(t, err)
+
+	release, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	defer release()
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = bulkhead.acquire(ctx, "newad", domain.PriorityInteractive)
+	assert.Equal(t, transCanceled, err.(domain.TransError).Code)
+	assert.Equal(t, 1, bulkhead.commands["newad"])
+	assert.Equal(t, 0, bulkhead.waiting)
+}
+
+func TestSendCommandQueueTimeout(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		time.Sleep(200 * time.Millisecond)
+		return []byte("status:TRANS_OK\n")
+	})
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	conf := TransConf{
+		Backends:        server.Address,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+		MaxConcurrency:  1,
+		QueueSize:       1,
+		QueueTimeout:    50 * time.Millisecond,
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := transFactory.MakeTransHandler().SendCommand(
+			context.Background(),
+			domain.TransCommand{Command: "transinfo"},
+		)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	resp, err := transFactory.MakeTransHandler().SendCommand(
+		context.Background(),
+		domain.TransCommand{Command: "transinfo"},
+	)
+	assert.Equal(t, []domain.TransField{}, resp)
+	assert.Equal(t, transQueueTimeout, err.(domain.TransError).Code)
+	assert.NoError(t, <-done)
+	logger.AssertExpectations(t)
+}
+
+func TestBulkheadPriorityWeights(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{PriorityWeights: "interactive=3|batch=2"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, bulkhead.lane(domain.PriorityInteractive).weight)
+	assert.Equal(t, 2, bulkhead.lane(domain.PriorityBatch).weight)
+	// unknown priorities wait with the interactive commands
+	assert.Equal(t, domain.PriorityInteractive, bulkhead.lane("").priority)
+
+	_, err = newTransBulkhead(TransConf{PriorityWeights: "urgent=3"}, nil)
+	assert.Error(t, err)
+}
+
+func TestBulkheadWeightedLanes(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{
+		MaxConcurrency:  1,
+		QueueSize:       10,
+		QueueTimeout:    time.Second,
+		PriorityWeights: "interactive=2|batch=1",
+	}, nil)
+	assert.NoError(t, err)
+	release, err := bulkhead.acquire(context.Background(), "start", domain.PriorityInteractive)
+	assert.NoError(t, err)
+
+	// queue three commands on each lane, in order
+	order := make(chan domain.Priority, 6)
+	queue := func(priority domain.Priority) {
+		release, err := bulkhead.acquire(context.Background(), "cmd", priority)
+		assert.NoError(t, err)
+		order <- priority
+		release()
+	}
+	for i := 0; i < 3; i++ {
+		go queue(domain.PriorityBatch)
+		time.Sleep(10 * time.Millisecond)
+		go queue(domain.PriorityInteractive)
+		time.Sleep(10 * time.Millisecond)
+	}
+	release()
+
+	var got []domain.Priority
+	for i := 0; i < 6; i++ {
+		got = append(got, <-order)
+	}
+	i, b := domain.PriorityInteractive, domain.PriorityBatch
+	assert.Equal(t, []domain.Priority{i, b, i, i, b, b}, got)
+}
+
+func TestBulkheadShedsBatch(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{
+		MaxConcurrency: 1,
+		QueueSize:      1,
+		QueueTimeout:   time.Second,
+	}, nil)
+	assert.NoError(t, err)
+	release, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+
+	batch := make(chan error)
+	go func() {
+		_, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityBatch)
+		batch <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	// the queue is full, so batch commands are rejected
+	_, err = bulkhead.acquire(context.Background(), "newad", domain.PriorityBatch)
+	assert.Equal(t, transQueueFull, err.(domain.TransError).Code)
+
+	// but interactive ones take the place of the queued batch command
+	interactive := make(chan error)
+	go func() {
+		release, err := bulkhead.acquire(context.Background(), "newad", domain.PriorityInteractive)
+		interactive <- err
+		release()
+	}()
+	err = <-batch
+	assert.Equal(t, domain.ErrorClassOverloaded, err.(domain.TransError).Class)
+	assert.Equal(t, transQueueFull, err.(domain.TransError).Code)
+	release()
+	assert.NoError(t, <-interactive)
+}
+
+func TestBulkheadTryAcquire(t *testing.T) {
+	bulkhead, err := newTransBulkhead(TransConf{
+		MaxConcurrency: 1,
+		QueueSize:      1,
+		QueueTimeout:   time.Second,
+	}, nil)
+	assert.NoError(t, err)
+
+	release, ok := bulkhead.tryAcquire("transinfo")
+	assert.True(t, ok)
+	// there are no free slots, and tryAcquire never waits for one
+	_, ok = bulkhead.tryAcquire("transinfo")
+	assert.False(t, ok)
+	release()
+	_, ok = bulkhead.tryAcquire("transinfo")
+	assert.True(t, ok)
+}
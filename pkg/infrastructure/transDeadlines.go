@@ -0,0 +1,78 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// transCanceled the caller went away before trans answered
+const transCanceled = "TRANS_CANCELED"
+
+// parseCommandTimeouts reads the timeouts of each command, written as
+// command=duration and separated by '|', like transinfo=2s|imgput=60s
+func parseCommandTimeouts(list string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+	for _, entry := range splitList(list) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid command timeout %q", entry)
+		}
+		timeout, err := time.ParseDuration(parts[1])
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid command timeout %q", entry)
+		}
+		timeouts[parts[0]] = timeout
+	}
+	return timeouts, nil
+}
+
+// commandTimeout returns how long the command can take, overall
+func (handler *trans) commandTimeout(cmd string) time.Duration {
+	if timeout, ok := handler.timeouts[cmd]; ok {
+		return timeout
+	}
+	return time.Duration(handler.conf.Timeout) * time.Second
+}
+
+// setReadDeadline limits how long the next reads on conn can take, if conn
+// supports deadlines. A timeout of 0 removes the limit
+func setReadDeadline(conn io.ReadWriter, timeout time.Duration) {
+	if dc, ok := conn.(interface{ SetReadDeadline(time.Time) error }); ok {
+		var deadline time.Time
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		_ = dc.SetReadDeadline(deadline) // nolint: gosec
+	}
+}
+
+// readError classifies an error reading from trans. Reads past their deadline are timeouts
+func readError(message string, err error) domain.TransError {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return domain.TransError{
+			Class:   domain.ErrorClassTimeout,
+			Code:    transTimeout,
+			Message: message,
+		}
+	}
+	return upstreamError(transBadResponse, message)
+}
+
+// contextError tells why ctx is done: either the deadline passed or the caller went away
+func contextError(ctx context.Context) domain.TransError {
+	code := transTimeout
+	if ctx.Err() == context.Canceled {
+		code = transCanceled
+	}
+	return domain.TransError{
+		Class:   domain.ErrorClassTimeout,
+		Code:    code,
+		Message: fmt.Sprintf("trans didn't answer in time: %s", ctx.Err()),
+	}
+}
@@ -0,0 +1,194 @@
+package infrastructure
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestParseCommandTimeouts(t *testing.T) {
+	timeouts, err := parseCommandTimeouts("transinfo=2s|imgput=1m")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"transinfo": 2 * time.Second,
+		"imgput":    time.Minute,
+	}, timeouts)
+
+	timeouts, err = parseCommandTimeouts("")
+	assert.NoError(t, err)
+	assert.Empty(t, timeouts)
+}
+
+func TestParseCommandTimeoutsInvalid(t *testing.T) {
+	for _, timeouts := range []string{"transinfo", "transinfo=2", "transinfo=0s", "transinfo=-1s", "transinfo=2s|"} {
+		_, err := parseCommandTimeouts(timeouts)
+		assert.Error(t, err, timeouts)
+	}
+	_, err := NewTextProtocolTransFactory(TransConf{CommandTimeouts: "transinfo"}, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestCommandTimeout(t *testing.T) {
+	handler := trans{
+		conf:     TransConf{Timeout: 15},
+		timeouts: map[string]time.Duration{"transinfo": 2 * time.Second},
+	}
+	assert.Equal(t, 2*time.Second, handler.commandTimeout("transinfo"))
+	assert.Equal(t, 15*time.Second, handler.commandTimeout("newad"))
+}
+
+func TestSendCommandCommandTimeout(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		time.Sleep(300 * time.Millisecond)
+		return []byte("status:TRANS_OK\n")
+	})
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	conf := TransConf{
+		Backends:        server.Address,
+		Timeout:         15,
+		CommandTimeouts: "transinfo=100ms",
+		AllowedCommands: "transinfo",
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	handler := transFactory.MakeTransHandler()
+	_, err = handler.SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.True(t, time.Since(start) < 300*time.Millisecond)
+	assert.Error(t, err)
+	assert.Equal(t, domain.ErrorClassTimeout, err.(domain.TransError).Class)
+	assert.Equal(t, transTimeout, err.(domain.TransError).Code)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandCanceled(t *testing.T) {
+	slow := int32(1)
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		if atomic.LoadInt32(&slow) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return []byte("status:TRANS_OK\n")
+	})
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	conf := TransConf{
+		Backends:        server.Address,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+		BreakerErrors:   1,
+		BreakerTimeout:  60 * time.Second,
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err = transFactory.MakeTransHandler().SendCommand(ctx, domain.TransCommand{Command: "transinfo"})
+	assert.Error(t, err)
+	assert.Equal(t, domain.ErrorClassTimeout, err.(domain.TransError).Class)
+	assert.Equal(t, transCanceled, err.(domain.TransError).Code)
+
+	// the backend isn't blamed for the canceled command, so its breaker is still closed
+	time.Sleep(300 * time.Millisecond)
+	atomic.StoreInt32(&slow, 0)
+	resp, err := transFactory.MakeTransHandler().SendCommand(
+		context.Background(),
+		domain.TransCommand{Command: "transinfo"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}}, resp)
+	logger.AssertExpectations(t)
+}
+
+// canceledAfterDial is a context that is done without closing its Done
+// channel, so dialing succeeds but the command is abandoned right after
+type canceledAfterDial struct {
+	context.Context
+}
+
+func (canceledAfterDial) Done() <-chan struct{} { return nil }
+func (canceledAfterDial) Err() error            { return context.Canceled }
+
+func TestSendOnceCanceledAfterDial(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	conf := TransConf{
+		Backends:        server.Address,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+		Balancer:        leastInflight,
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &MockLoggerInfrastructure{}, nil)
+	assert.NoError(t, err)
+	handler := transFactory.MakeTransHandler().(*trans)
+
+	_, err = handler.sendOnce(canceledAfterDial{context.Background()}, []byte("cmd:transinfo\nend\n"), nil)
+	assert.Equal(t, transCanceled, err.(domain.TransError).Code)
+	// the backend was released, so it has no commands in flight
+	assert.Equal(t, 0, handler.backends.backends[0].inflight)
+}
+
+func TestSendCommandGreetingTimeout(t *testing.T) {
+	// a server that accepts connections but never greets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close() //nolint: errcheck, megacheck
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			conn.Close() //nolint: errcheck, megacheck
+		}
+	}()
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	conf := TransConf{
+		Backends:        listener.Addr().String(),
+		Timeout:         15,
+		GreetingTimeout: 100 * time.Millisecond,
+		AllowedCommands: "transinfo",
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+
+	start := time.Now()
+	handler := transFactory.MakeTransHandler()
+	_, err = handler.SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Error(t, err)
+	assert.Equal(t, domain.ErrorClassTimeout, err.(domain.TransError).Class)
+	assert.Equal(t, transTimeout, err.(domain.TransError).Code)
+	logger.AssertExpectations(t)
+}
+
+func TestContextError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, transCanceled, contextError(ctx).Code)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	<-ctx.Done()
+	assert.Equal(t, transTimeout, contextError(ctx).Code)
+}
@@ -0,0 +1,205 @@
+package infrastructure
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// safeName matches the command names and param keys that can be written on
+// the protocol without breaking its framing
+var safeName = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`) // nolint: gochecknoglobals
+
+// reservedKeys the keys that frame a command on the protocol. Params with
+// them would send another command, commit a dry run or end the command early
+var reservedKeys = map[string]bool{"cmd": true, "commit": true, "end": true, "blob": true} // nolint: gochecknoglobals
+
+// transEncoder writes trans commands using the trans text protocol.
+// For the command format, see: https://scmcoord.com/wiki/Trans#Protocol
+type transEncoder struct {
+	// nullAsEmpty sends null params as empty values instead of omitting them
+	nullAsEmpty bool
+	// strict rejects the whole command if any param can not be encoded.
+	// Otherwise, those params are left out of the command
+	strict bool
+	// transliterate replaces the characters that can not be encoded
+	// with an approximation, instead of rejecting the value
+	transliterate bool
+	// charset the character set keys and values are encoded with
+	charset transCharset
+	// multilineBlobCommands the commands whose multi-line values are sent as
+	// blobs. On any other command, multi-line values are rejected
+	multilineBlobCommands []string
+}
+
+// newTransEncoder returns a transEncoder configured as stated by conf,
+// that encodes keys and values on the given charset
+func newTransEncoder(conf TransConf, charset transCharset) transEncoder {
+	return transEncoder{
+		charset:               charset,
+		nullAsEmpty:           conf.NullAsEmpty,
+		strict:                conf.Strict,
+		transliterate:         conf.Transliterate,
+		multilineBlobCommands: strings.Split(conf.MultilineBlobCommands, "|"),
+	}
+}
+
+// appendCmd Appends the command to the buffer. Params whose value can not be
+// represented on trans are reported as a domain.ParamsError. On strict mode,
+// params that can not be encoded are reported as well. Dry run commands are
+// not committed, so trans only validates them
+func (e transEncoder) appendCmd(buf []byte, command domain.TransCommand) ([]byte, error) {
+	cmd := command.Command
+	if !safeName.MatchString(cmd) {
+		return nil, fmt.Errorf("invalid command name %q", cmd)
+	}
+	var paramsErr domain.ParamsError
+	promoteMultiline := e.promotesMultiline(cmd)
+	buf = append(buf, "cmd:"...)
+	buf = append(buf, cmd...)
+	buf = append(buf, '\n')
+	for _, param := range command.Params {
+		var err error
+		buf, err = e.appendParam(buf, param, promoteMultiline)
+		if err == nil {
+			continue
+		}
+		if _, invalid := err.(invalidParamError); invalid || e.strict {
+			paramsErr = append(paramsErr, domain.ParamError{Param: param.Key, Reason: err.Error()})
+		}
+	}
+	if len(paramsErr) > 0 {
+		return nil, paramsErr
+	}
+	if command.DryRun {
+		buf = append(buf, "commit:0"...)
+	} else {
+		buf = append(buf, "commit:1"...)
+	}
+	buf = append(buf, "\nend\n"...)
+	return buf, nil
+}
+
+// promotesMultiline tells if multi-line values of the command are sent as blobs
+func (e transEncoder) promotesMultiline(cmd string) bool {
+	for _, command := range e.multilineBlobCommands {
+		if command == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidParamError is returned for params that can never be sent to trans,
+// no matter if the encoder is strict or not
+type invalidParamError string
+
+func (e invalidParamError) Error() string {
+	return string(e)
+}
+
+// appendParam appends a single param to the buffer. If the param can not be
+// encoded, the buffer is returned untouched along with the reason.
+// Values spanning several lines would inject new lines on the command, so
+// they are either sent as blobs, if promoteMultiline is set, or rejected
+func (e transEncoder) appendParam(buf []byte, param domain.TransParams, promoteMultiline bool) ([]byte, error) {
+	if !safeName.MatchString(param.Key) {
+		return buf, invalidParamError("key may only contain letters, digits, '_', '.' and '-'")
+	}
+	if reservedKeys[strings.ToLower(param.Key)] {
+		return buf, invalidParamError("key is reserved by the trans protocol")
+	}
+	value, ok, err := e.formatValue(param.Value)
+	if err != nil || !ok {
+		return buf, err
+	}
+	if param.Blob {
+		if _, isString := param.Value.(string); !isString {
+			return buf, invalidParamError("blob must be a base64 string")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return buf, fmt.Errorf("blob is not valid base64")
+		}
+		return appendBlob(buf, param.Key, decoded), nil
+	}
+	if e.transliterate {
+		value = transliterate(value, e.charset)
+	}
+	value, err = e.charset.encode(value)
+	if err != nil {
+		return buf, fmt.Errorf("value can not be encoded as %s", e.charset.name)
+	}
+	if strings.IndexByte(value, '\n') != -1 {
+		if !promoteMultiline {
+			return buf, invalidParamError("value must not contain new lines")
+		}
+		return appendBlob(buf, param.Key, []byte(value)), nil
+	}
+	buf = append(buf, param.Key...)
+	buf = append(buf, ':')
+	buf = append(buf, value...)
+	buf = append(buf, '\n')
+	return buf, nil
+}
+
+// appendBlob appends a blob field to the buffer. As its length is known,
+// the value may contain any byte
+func appendBlob(buf []byte, key string, value []byte) []byte {
+	buf = append(buf, "blob:"...)
+	buf = strconv.AppendInt(buf, int64(len(value)), 10)
+	buf = append(buf, ':')
+	buf = append(buf, key...)
+	buf = append(buf, '\n')
+	buf = append(buf, value...)
+	buf = append(buf, '\n')
+	return buf
+}
+
+// formatValue returns the trans representation of a param value. Integers are
+// written without decimal point, booleans as 1 or 0, and nulls are omitted
+// (ok is false) unless the encoder is configured to send them empty.
+// Values of any other type can not be sent and produce an error
+func (e transEncoder) formatValue(value interface{}) (formatted string, ok bool, err error) {
+	switch v := value.(type) {
+	case string:
+		return v, true, nil
+	case nil:
+		return "", e.nullAsEmpty, nil
+	case bool:
+		if v {
+			return "1", true, nil
+		}
+		return "0", true, nil
+	case float64:
+		// integral numbers are written without decimal point nor exponent
+		return strconv.FormatFloat(v, 'f', -1, 64), true, nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true, nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), true, nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), true, nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), true, nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true, nil
+	case int64:
+		return strconv.FormatInt(v, 10), true, nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), true, nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), true, nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), true, nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), true, nil
+	case uint64:
+		return strconv.FormatUint(v, 10), true, nil
+	}
+	return "", false, invalidParamError(fmt.Sprintf("unsupported value type %T", value))
+}
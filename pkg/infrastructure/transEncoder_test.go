@@ -0,0 +1,189 @@
+package infrastructure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+	"golang.org/x/text/encoding/charmap"
+)
+
+var latin1 = transCharset{name: "ISO-8859-1", charmap: charmap.ISO8859_1} // nolint: gochecknoglobals
+
+func TestAppendCmdScalarTypes(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+	params := []domain.TransParams{
+		{Key: "name", Value: "edgar"},
+		{Key: "ad_id", Value: float64(1980)},
+		{Key: "price", Value: 12.5},
+		{Key: "big", Value: float64(12345678901234567)},
+		{Key: "region", Value: 13},
+		{Key: "company", Value: true},
+		{Key: "hidden", Value: false},
+		{Key: "phone", Value: nil},
+	}
+	expected := "cmd:test\nname:edgar\nad_id:1980\nprice:12.5\nbig:12345678901234568\n" +
+		"region:13\ncompany:1\nhidden:0\ncommit:1\nend\n"
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(buf))
+}
+
+func TestAppendCmdNullAsEmpty(t *testing.T) {
+	encoder := newTransEncoder(TransConf{NullAsEmpty: true}, latin1)
+	params := []domain.TransParams{
+		{Key: "phone", Value: nil},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:test\nphone:\ncommit:1\nend\n", string(buf))
+}
+
+func TestAppendCmdDryRun(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+	params := []domain.TransParams{
+		{Key: "email", Value: "user@test.com"},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:test\nemail:user@test.com\ncommit:0\nend\n", string(buf))
+}
+
+func TestAppendCmdUnsupportedTypes(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+	params := []domain.TransParams{
+		{Key: "name", Value: "edgar"},
+		{Key: "address", Value: map[string]interface{}{"street": "x"}},
+		{Key: "tags", Value: []interface{}{"a"}},
+		{Key: "image", Value: float64(1), Blob: true},
+	}
+	expectedErr := domain.ParamsError{
+		{Param: "address", Reason: "unsupported value type map[string]interface {}"},
+		{Param: "tags", Reason: "unsupported value type []interface {}"},
+		{Param: "image", Reason: "blob must be a base64 string"},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.Equal(t, expectedErr, err)
+	assert.Nil(t, buf)
+}
+
+func TestAppendCmdStrictRejectsParams(t *testing.T) {
+	encoder := newTransEncoder(TransConf{Strict: true}, latin1)
+	params := []domain.TransParams{
+		{Key: "name", Value: "edgar"},
+		{Key: "subject", Value: "“Auto” 🚗"},
+		{Key: "llave€", Value: "ok"},
+		{Key: "image", Value: "not base64!", Blob: true},
+	}
+	expectedErr := domain.ParamsError{
+		{Param: "subject", Reason: "value can not be encoded as ISO-8859-1"},
+		{Param: "llave€", Reason: "key may only contain letters, digits, '_', '.' and '-'"},
+		{Param: "image", Reason: "blob is not valid base64"},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.Equal(t, expectedErr, err)
+	assert.Nil(t, buf)
+}
+
+func TestAppendCmdNotStrictSkipsParams(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+	params := []domain.TransParams{
+		{Key: "name", Value: "edgar"},
+		{Key: "subject", Value: "“Auto” 🚗"},
+		{Key: "image", Value: "not base64!", Blob: true},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:test\nname:edgar\ncommit:1\nend\n", string(buf))
+}
+
+func TestAppendCmdTransliterate(t *testing.T) {
+	encoder := newTransEncoder(TransConf{Strict: true, Transliterate: true}, latin1)
+	params := []domain.TransParams{
+		{Key: "subject", Value: "“Auto” – Łódź 🚗 ñandú…"},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.NoError(t, err)
+	assert.Equal(t, "cmd:test\nsubject:\"Auto\" - L\xf3dz ? \xf1and\xfa...\ncommit:1\nend\n", string(buf))
+}
+
+func TestAppendCmdRejectsInjectedLines(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+	params := []domain.TransParams{
+		{Key: "email", Value: "user@test.com\ncommit:1\nend"},
+		{Key: "name", Value: "edgar"},
+	}
+	expectedErr := domain.ParamsError{
+		{Param: "email", Reason: "value must not contain new lines"},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.Equal(t, expectedErr, err)
+	assert.Nil(t, buf)
+}
+
+func TestAppendCmdRejectsUnsafeKeys(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+	reason := "key may only contain letters, digits, '_', '.' and '-'"
+	params := []domain.TransParams{
+		{Key: "commit:1\nemail", Value: "x"},
+		{Key: "email:x", Value: "x"},
+		{Key: "", Value: "x"},
+		{Key: "image\n", Value: "ZWRnYXI=", Blob: true},
+		{Key: "ad_params.0.value-1", Value: "ok"},
+	}
+	expectedErr := domain.ParamsError{
+		{Param: "commit:1\nemail", Reason: reason},
+		{Param: "email:x", Reason: reason},
+		{Param: "", Reason: reason},
+		{Param: "image\n", Reason: reason},
+	}
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: test, Params: params})
+	assert.Equal(t, expectedErr, err)
+	assert.Nil(t, buf)
+}
+
+func TestAppendCmdRejectsReservedKeys(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+	for _, key := range []string{"cmd", "commit", "end", "blob", "CMD"} {
+		buf, err := encoder.appendCmd(nil, domain.TransCommand{
+			Command: test,
+			Params:  []domain.TransParams{{Key: key, Value: "newad"}},
+			DryRun:  true,
+		})
+		assert.Equal(t, domain.ParamsError{{Param: key, Reason: "key is reserved by the trans protocol"}}, err, key)
+		assert.Nil(t, buf, key)
+	}
+}
+
+func TestAppendCmdRejectsUnsafeCommand(t *testing.T) {
+	encoder := newTransEncoder(TransConf{}, latin1)
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: "transinfo\ncommit:1"})
+	assert.Error(t, err)
+	assert.Nil(t, buf)
+}
+
+func TestAppendCmdPromotesMultilineToBlob(t *testing.T) {
+	encoder := newTransEncoder(TransConf{MultilineBlobCommands: "newad|bump_ad"}, latin1)
+	params := []domain.TransParams{
+		{Key: "body", Value: "línea 1\nend\ncommit:1"},
+		{Key: "name", Value: "edgar"},
+	}
+	expected := "cmd:newad\nblob:20:body\nl\xednea 1\nend\ncommit:1\nname:edgar\ncommit:1\nend\n"
+
+	buf, err := encoder.appendCmd(nil, domain.TransCommand{Command: "newad", Params: params})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(buf))
+
+	_, err = encoder.appendCmd(nil, domain.TransCommand{Command: "get_account", Params: params})
+	assert.Error(t, err)
+}
@@ -0,0 +1,213 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+const (
+	// hedgeSamples how many of the latest latencies of each command are kept
+	hedgeSamples = 100
+	// hedgeMinSamples how many latencies of a command must be known before it is hedged
+	hedgeMinSamples = 20
+)
+
+// transHedger decides when read-only commands are hedged: if an attempt
+// wasn't answered after the HedgePercentile of the latest latencies of its
+// command, the command is sent again to another backend. Only HedgeCommands
+// are hedged, and only once enough of their latencies are known. A nil
+// transHedger never hedges. Hedged commands may run twice, so they must be
+// IdempotentCommands
+type transHedger struct {
+	commands   map[string]bool
+	percentile float64
+	minDelay   time.Duration
+
+	mutex     sync.Mutex
+	latencies map[string]*transLatencies
+}
+
+// transLatencies the latest latencies of a command, as a ring
+type transLatencies struct {
+	values []time.Duration
+	next   int
+}
+
+// newTransHedger creates the hedger configured on conf, or nil if there are no
+// HedgeCommands. It fails if any of them isn't on IdempotentCommands
+func newTransHedger(conf TransConf) (*transHedger, error) {
+	commands := splitList(conf.HedgeCommands)
+	if len(commands) == 0 {
+		return nil, nil
+	}
+	if conf.HedgePercentile <= 0 || conf.HedgePercentile >= 100 {
+		return nil, fmt.Errorf("invalid hedge percentile %d", conf.HedgePercentile)
+	}
+	hedger := &transHedger{
+		commands:   make(map[string]bool),
+		percentile: float64(conf.HedgePercentile) / 100,
+		minDelay:   conf.HedgeMinDelay,
+		latencies:  make(map[string]*transLatencies),
+	}
+	idempotent := make(map[string]bool)
+	for _, cmd := range splitList(conf.IdempotentCommands) {
+		idempotent[cmd] = true
+	}
+	for _, cmd := range commands {
+		if !idempotent[cmd] {
+			return nil, fmt.Errorf("hedged command %s isn't on the idempotent commands", cmd)
+		}
+		hedger.commands[cmd] = true
+	}
+	return hedger, nil
+}
+
+// delay returns how long an attempt of cmd waits before it is hedged, never
+// less than minDelay, and whether cmd should be hedged at all
+func (h *transHedger) delay(cmd string) (time.Duration, bool) {
+	if h == nil || !h.commands[cmd] {
+		return 0, false
+	}
+	h.mutex.Lock()
+	latencies, ok := h.latencies[cmd]
+	if !ok || len(latencies.values) < hedgeMinSamples {
+		h.mutex.Unlock()
+		return 0, false
+	}
+	values := append([]time.Duration(nil), latencies.values...)
+	h.mutex.Unlock()
+
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	delay := values[int(math.Ceil(h.percentile*float64(len(values))))-1]
+	if delay < h.minDelay {
+		delay = h.minDelay
+	}
+	return delay, true
+}
+
+// observe records how long cmd took to be answered
+func (h *transHedger) observe(cmd string, elapsed time.Duration) {
+	if h == nil || !h.commands[cmd] {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	latencies, ok := h.latencies[cmd]
+	if !ok {
+		latencies = &transLatencies{}
+		h.latencies[cmd] = latencies
+	}
+	if len(latencies.values) < hedgeSamples {
+		latencies.values = append(latencies.values, elapsed)
+		return
+	}
+	latencies.values[latencies.next] = elapsed
+	latencies.next = (latencies.next + 1) % hedgeSamples
+}
+
+// transBackendSet the backends the attempts of a hedged command were sent
+// to, so each attempt goes to a different backend. A nil set is always empty
+type transBackendSet struct {
+	mutex    sync.Mutex
+	backends map[*transBackend]bool
+}
+
+// add puts the backend on the set
+func (s *transBackendSet) add(backend *transBackend) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.backends == nil {
+		s.backends = make(map[*transBackend]bool)
+	}
+	s.backends[backend] = true
+}
+
+// copy returns the backends on the set
+func (s *transBackendSet) copy() map[*transBackend]bool {
+	backends := make(map[*transBackend]bool)
+	if s == nil {
+		return backends
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for backend := range s.backends {
+		backends[backend] = true
+	}
+	return backends
+}
+
+// transHedgeResult the outcome of an attempt of a hedged command
+type transHedgeResult struct {
+	resp  []domain.TransField
+	err   error
+	hedge bool
+}
+
+// sendHedged sends the command and, if it wasn't answered after delay, sends
+// it again to another backend. The hedge takes its own slots on the adaptive
+// limiter and the bulkhead, and it isn't sent if none is free right away. The
+// first successful answer wins and the other attempt is canceled. If both
+// attempts fail, the error of the first one is returned. The latency recorded
+// is the one of the whole command, so the hedge delay follows what the first
+// attempts take even when hedges win
+func (handler *trans) sendHedged(
+	ctx context.Context,
+	command domain.TransCommand,
+	buf []byte,
+	delay time.Duration,
+) ([]domain.TransField, error) {
+	cmd := command.Command
+	start := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	used := &transBackendSet{}
+	results := make(chan transHedgeResult, 2)
+	send := func(hedge bool, release func()) {
+		defer release()
+		resp, err := handler.sendOnce(ctx, buf, used)
+		results <- transHedgeResult{resp: resp, err: err, hedge: hedge}
+	}
+	go send(false, func() {})
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending, hedged := 1, false
+	var failed *transHedgeResult
+	for {
+		select {
+		case <-timer.C:
+			release, ok := handler.acquireHedge(command)
+			if !ok {
+				handler.logger.Debug("Not hedging command %s, trans is at its concurrency limit\n", cmd)
+				continue
+			}
+			handler.logger.Debug("Hedging command %s after %s\n", cmd, delay)
+			pending, hedged = pending+1, true
+			go send(true, release)
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				handler.hedger.observe(cmd, time.Since(start))
+				if hedged {
+					handler.metrics.CollectHedge(cmd, result.hedge)
+				}
+				return result.resp, nil
+			}
+			if failed == nil || !result.hedge {
+				failed = &result
+			}
+			if pending == 0 {
+				return failed.resp, failed.err
+			}
+		}
+	}
+}
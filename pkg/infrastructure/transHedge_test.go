@@ -0,0 +1,194 @@
+package infrastructure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestNewTransHedger(t *testing.T) {
+	hedger, err := newTransHedger(TransConf{HedgePercentile: 95})
+	assert.NoError(t, err)
+	assert.Nil(t, hedger)
+	hedger.observe("transinfo", time.Second)
+	_, ok := hedger.delay("transinfo")
+	assert.False(t, ok)
+
+	for _, percentile := range []int{0, 100} {
+		_, err := newTransHedger(TransConf{HedgeCommands: "transinfo", HedgePercentile: percentile})
+		assert.Error(t, err, percentile)
+	}
+
+	// hedged commands may run twice, so they must be idempotent
+	_, err = newTransHedger(TransConf{
+		HedgeCommands:      "transinfo|newad",
+		HedgePercentile:    95,
+		IdempotentCommands: "transinfo",
+	})
+	assert.EqualError(t, err, "hedged command newad isn't on the idempotent commands")
+}
+
+func TestHedgerDelay(t *testing.T) {
+	hedger, err := newTransHedger(TransConf{
+		HedgeCommands:      "transinfo|get_account",
+		HedgePercentile:    95,
+		HedgeMinDelay:      5 * time.Millisecond,
+		IdempotentCommands: "transinfo|get_account",
+	})
+	assert.NoError(t, err)
+
+	// commands aren't hedged until enough latencies are known
+	for i := 1; i < hedgeMinSamples; i++ {
+		hedger.observe("transinfo", time.Millisecond)
+	}
+	_, ok := hedger.delay("transinfo")
+	assert.False(t, ok)
+	hedger.observe("transinfo", time.Millisecond)
+	delay, ok := hedger.delay("transinfo")
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Millisecond, delay)
+
+	// only the latest latencies are kept
+	for i := 1; i <= hedgeSamples; i++ {
+		hedger.observe("transinfo", time.Duration(i)*time.Millisecond)
+	}
+	delay, ok = hedger.delay("transinfo")
+	assert.True(t, ok)
+	assert.Equal(t, 95*time.Millisecond, delay)
+
+	// commands that aren't safe are never hedged
+	hedger.observe("newad", time.Millisecond)
+	_, ok = hedger.delay("newad")
+	assert.False(t, ok)
+}
+
+// newHedgeServer starts a mock trans that answers after delay, returning its name
+func newHedgeServer(name string, delay time.Duration) *MockTransServer {
+	server := NewMockTransServer()
+	server.SetHandler(func(input []byte) []byte {
+		time.Sleep(delay)
+		return []byte("status:TRANS_OK\nserver:" + name + "\n")
+	})
+	return server
+}
+
+func newHedgeHandler(t *testing.T, slow, fast *MockTransServer) *trans {
+	conf := TransConf{
+		Backends:           slow.Address + "|" + fast.Address,
+		Timeout:            15,
+		AllowedCommands:    "transinfo|newad",
+		HedgeCommands:      "transinfo",
+		HedgePercentile:    95,
+		IdempotentCommands: "transinfo",
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	handler := transFactory.MakeTransHandler().(*trans)
+	for i := 0; i < hedgeMinSamples; i++ {
+		handler.hedger.observe("transinfo", 10*time.Millisecond)
+	}
+	return handler
+}
+
+func TestSendCommandHedged(t *testing.T) {
+	slow := newHedgeServer("slow", time.Second)
+	defer slow.Close()
+	fast := newHedgeServer("fast", 0)
+	defer fast.Close()
+	handler := newHedgeHandler(t, slow, fast)
+
+	// the first attempt goes to the slow backend, so the hedge wins
+	start := time.Now()
+	resp, err := handler.SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}, {Key: "server", Value: "fast"}}, resp)
+	assert.True(t, time.Since(start) < time.Second)
+	// the latency recorded counts from the first attempt, not from the hedge
+	handler.hedger.mutex.Lock()
+	assert.True(t, handler.hedger.latencies["transinfo"].values[hedgeMinSamples] >= 10*time.Millisecond)
+	handler.hedger.mutex.Unlock()
+	// the slots of both attempts are given back once the loser is canceled
+	assert.Eventually(t, func() bool {
+		handler.bulkhead.mutex.Lock()
+		defer handler.bulkhead.mutex.Unlock()
+		return handler.bulkhead.inflight == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSendCommandHedgeWithoutSlot(t *testing.T) {
+	slow := newHedgeServer("slow", 100*time.Millisecond)
+	defer slow.Close()
+	fast := newHedgeServer("fast", 0)
+	defer fast.Close()
+	conf := TransConf{
+		Backends:           slow.Address + "|" + fast.Address,
+		Timeout:            15,
+		AllowedCommands:    "transinfo",
+		HedgeCommands:      "transinfo",
+		HedgePercentile:    95,
+		IdempotentCommands: "transinfo",
+		MaxConcurrency:     1,
+		QueueSize:          1,
+		QueueTimeout:       time.Second,
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	handler := transFactory.MakeTransHandler().(*trans)
+	for i := 0; i < hedgeMinSamples; i++ {
+		handler.hedger.observe("transinfo", 10*time.Millisecond)
+	}
+
+	// the command takes the only slot, so there's none left to hedge it
+	resp, err := handler.SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}, {Key: "server", Value: "slow"}}, resp)
+}
+
+func TestSendCommandNotHedged(t *testing.T) {
+	slow := newHedgeServer("slow", 100*time.Millisecond)
+	defer slow.Close()
+	fast := newHedgeServer("fast", 0)
+	defer fast.Close()
+	handler := newHedgeHandler(t, slow, fast)
+
+	// newad isn't safe to hedge, so it waits for the slow backend
+	resp, err := handler.SendCommand(context.Background(), domain.TransCommand{Command: "newad"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}, {Key: "server", Value: "slow"}}, resp)
+}
+
+func TestSendCommandHedgeFailed(t *testing.T) {
+	slow := newHedgeServer("slow", 100*time.Millisecond)
+	defer slow.Close()
+	closed := NewMockTransServer()
+	closed.Close()
+	conf := TransConf{
+		Backends:           slow.Address + "|" + closed.Address,
+		Timeout:            15,
+		AllowedCommands:    "transinfo",
+		HedgeCommands:      "transinfo",
+		HedgePercentile:    95,
+		IdempotentCommands: "transinfo",
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	logger.On("Error")
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	handler := transFactory.MakeTransHandler().(*trans)
+	for i := 0; i < hedgeMinSamples; i++ {
+		handler.hedger.observe("transinfo", 10*time.Millisecond)
+	}
+
+	// the hedge can't reach its backend, so the first attempt is awaited
+	resp, err := handler.SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}, {Key: "server", Value: "slow"}}, resp)
+}
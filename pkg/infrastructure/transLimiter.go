@@ -0,0 +1,145 @@
+package infrastructure
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// transLimitExceeded the command was shed because trans is at its concurrency limit
+const transLimitExceeded = "TRANS_LIMIT_EXCEEDED"
+
+// transLimiter adapts how many commands can be in flight to how trans is
+// doing, with additive increase and multiplicative decrease. Every attempt
+// answered within latency raises the limit by 1/limit, so it grows by one
+// each round of commands, as long as the limit is actually being used.
+// Attempts slower than latency, timeouts and busy greetings multiply it by
+// backoff. latency is meant for commands with the default timeout, and
+// scales with the timeout of the rest, so slow commands don't look like load. Commands over the limit are shed right away. Batch commands are
+// shed once batch of the limit is in flight, leaving the rest for interactive
+// commands. A nil transLimiter never sheds commands
+type transLimiter struct {
+	min     float64
+	max     float64
+	latency time.Duration
+	timeout time.Duration
+	backoff float64
+	batch   float64
+	metrics *TransCollector
+
+	mutex    sync.Mutex
+	limit    float64
+	inflight int
+}
+
+// newTransLimiter creates the limiter configured on conf, or nil if AdaptiveLimit is disabled
+func newTransLimiter(conf TransConf, metrics *TransCollector) *transLimiter {
+	if !conf.AdaptiveLimit {
+		return nil
+	}
+	limiter := &transLimiter{
+		min:     math.Max(float64(conf.LimitMin), 1),
+		max:     float64(conf.LimitMax),
+		latency: conf.LimitLatency,
+		timeout: time.Duration(conf.Timeout) * time.Second,
+		backoff: float64(conf.LimitBackoff) / 100,
+		batch:   float64(conf.LimitBatch) / 100,
+		metrics: metrics,
+	}
+	limiter.setLimit(float64(conf.LimitInitial))
+	return limiter
+}
+
+// acquire counts a command in flight, or sheds it if the limit of its
+// priority was reached. The returned function must be called once the command is done
+func (l *transLimiter) acquire(cmd string, priority domain.Priority) (func(), error) {
+	release, ok := l.tryAcquire(priority)
+	if !ok {
+		l.metrics.CollectRejected(cmd, transLimitExceeded)
+		return nil, domain.TransError{
+			Class:   domain.ErrorClassOverloaded,
+			Code:    transLimitExceeded,
+			Message: "too many commands in flight to trans",
+		}
+	}
+	return release, nil
+}
+
+// tryAcquire counts a command in flight if the limit of its priority wasn't
+// reached. The returned function must be called once the command is done
+func (l *transLimiter) tryAcquire(priority domain.Priority) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	limit := int(l.limit)
+	if priority == domain.PriorityBatch {
+		// batch commands always get at least one place
+		limit = int(math.Max(l.limit*l.batch, 1))
+	}
+	if l.inflight >= limit {
+		return nil, false
+	}
+	l.inflight++
+	return l.release, true
+}
+
+// release counts a command that is no longer in flight
+func (l *transLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.inflight--
+}
+
+// observe adapts the limit to an attempt of a command with the given timeout
+// that took elapsed and returned err. Errors that say nothing about the load
+// of trans are ignored
+func (l *transLimiter) observe(elapsed, timeout time.Duration, err error) {
+	if l == nil {
+		return
+	}
+	overloaded := elapsed > l.threshold(timeout)
+	switch e := err.(type) {
+	case nil:
+	case domain.BusyError:
+		overloaded = true
+	case domain.TransError:
+		if e.Class != domain.ErrorClassTimeout || e.Code == transCanceled {
+			return
+		}
+		overloaded = true
+	default:
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if overloaded {
+		l.setLimit(l.limit * l.backoff)
+	} else if float64(l.inflight)*2 >= l.limit {
+		l.setLimit(l.limit + 1/l.limit)
+	}
+}
+
+// threshold returns the latency over which an attempt of a command with the
+// given timeout means trans is overloaded
+func (l *transLimiter) threshold(timeout time.Duration) time.Duration {
+	if l.timeout <= 0 || timeout <= 0 {
+		return l.latency
+	}
+	return time.Duration(float64(l.latency) * float64(timeout) / float64(l.timeout))
+}
+
+// setLimit changes the limit, keeping it between min and max. The mutex must be held
+func (l *transLimiter) setLimit(limit float64) {
+	limit = math.Max(limit, l.min)
+	if l.max > 0 {
+		limit = math.Min(limit, l.max)
+	}
+	if int(limit) != int(l.limit) {
+		l.metrics.CollectLimit(int(limit))
+	}
+	l.limit = limit
+}
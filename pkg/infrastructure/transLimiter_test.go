@@ -0,0 +1,180 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func newTestLimiter(initial int) *transLimiter {
+	return newTransLimiter(TransConf{
+		AdaptiveLimit: true,
+		LimitInitial:  initial,
+		LimitMin:      2,
+		LimitMax:      4,
+		LimitLatency:  100 * time.Millisecond,
+		LimitBackoff:  50,
+		Timeout:       1,
+	}, nil)
+}
+
+func TestLimiterDisabled(t *testing.T) {
+	limiter := newTransLimiter(TransConf{}, nil)
+	assert.Nil(t, limiter)
+	release, err := limiter.acquire("newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	release()
+	limiter.observe(time.Minute, time.Second, nil)
+}
+
+func TestLimiterSheds(t *testing.T) {
+	limiter := newTestLimiter(2)
+	for i := 0; i < 2; i++ {
+		_, err := limiter.acquire("newad", domain.PriorityInteractive)
+		assert.NoError(t, err)
+	}
+	_, err := limiter.acquire("newad", domain.PriorityInteractive)
+	assert.Equal(t, domain.ErrorClassOverloaded, err.(domain.TransError).Class)
+	assert.Equal(t, transLimitExceeded, err.(domain.TransError).Code)
+}
+
+func TestLimiterShedsBatchFirst(t *testing.T) {
+	limiter := newTransLimiter(TransConf{AdaptiveLimit: true, LimitInitial: 4, LimitBatch: 50}, nil)
+	for i := 0; i < 2; i++ {
+		_, err := limiter.acquire("newad", domain.PriorityBatch)
+		assert.NoError(t, err)
+	}
+	// half of the limit is in flight, so batch commands are shed
+	_, err := limiter.acquire("newad", domain.PriorityBatch)
+	assert.Equal(t, transLimitExceeded, err.(domain.TransError).Code)
+	// but interactive ones are still let through, up to the whole limit
+	for i := 0; i < 2; i++ {
+		_, err := limiter.acquire("newad", domain.PriorityInteractive)
+		assert.NoError(t, err)
+	}
+	_, err = limiter.acquire("newad", domain.PriorityInteractive)
+	assert.Equal(t, transLimitExceeded, err.(domain.TransError).Code)
+
+	// batch commands always get at least one place
+	limiter = newTransLimiter(TransConf{AdaptiveLimit: true, LimitInitial: 1, LimitBatch: 50}, nil)
+	release, err := limiter.acquire("newad", domain.PriorityBatch)
+	assert.NoError(t, err)
+	release()
+}
+
+func TestLimiterIncrease(t *testing.T) {
+	limiter := newTestLimiter(2)
+	// the limit doesn't grow while it isn't used
+	limiter.observe(time.Millisecond, time.Second, nil)
+	assert.Equal(t, 2.0, limiter.limit)
+
+	_, err := limiter.acquire("newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	limiter.observe(time.Millisecond, time.Second, nil)
+	assert.Equal(t, 2.5, limiter.limit)
+	// it stops growing with less than half of it in use
+	limiter.observe(time.Millisecond, time.Second, nil)
+	assert.Equal(t, 2.5, limiter.limit)
+
+	// and it never grows beyond max
+	_, err = limiter.acquire("newad", domain.PriorityInteractive)
+	assert.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		limiter.observe(time.Millisecond, time.Second, nil)
+	}
+	assert.Equal(t, 4.0, limiter.limit)
+}
+
+func TestLimiterDecrease(t *testing.T) {
+	cases := map[string]error{
+		"slow":    nil,
+		"busy":    domain.BusyError{},
+		"timeout": domain.TransError{Class: domain.ErrorClassTimeout, Code: transTimeout},
+	}
+	for name, err := range cases {
+		t.Run(name, func(t *testing.T) {
+			limiter := newTestLimiter(4)
+			elapsed := time.Millisecond
+			if err == nil {
+				elapsed = time.Second
+			}
+			limiter.observe(elapsed, time.Second, err)
+			assert.Equal(t, 2.0, limiter.limit)
+			// and it never goes below min
+			limiter.observe(elapsed, time.Second, err)
+			assert.Equal(t, 2.0, limiter.limit)
+		})
+	}
+}
+
+func TestLimiterLatencyFollowsTimeout(t *testing.T) {
+	limiter := newTestLimiter(4)
+	// a command with twice the default timeout may take twice the latency
+	limiter.observe(150*time.Millisecond, 2*time.Second, nil)
+	assert.Equal(t, 4.0, limiter.limit)
+	limiter.observe(250*time.Millisecond, 2*time.Second, nil)
+	assert.Equal(t, 2.0, limiter.limit)
+
+	// while one with half of it is expected to be faster
+	limiter = newTestLimiter(4)
+	limiter.observe(75*time.Millisecond, 500*time.Millisecond, nil)
+	assert.Equal(t, 2.0, limiter.limit)
+}
+
+func TestLimiterIgnores(t *testing.T) {
+	for _, err := range []error{
+		errors.New("invalid command"),
+		upstreamError(transUnavailable, "Error connecting with trans server"),
+		domain.TransError{Class: domain.ErrorClassTimeout, Code: transCanceled},
+	} {
+		limiter := newTestLimiter(4)
+		limiter.observe(time.Second, time.Second, err)
+		assert.Equal(t, 4.0, limiter.limit, err.Error())
+	}
+}
+
+func TestSendCommandLimitExceeded(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		time.Sleep(200 * time.Millisecond)
+		return []byte("status:TRANS_OK\n")
+	})
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	conf := TransConf{
+		Backends:        server.Address,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+		AdaptiveLimit:   true,
+		LimitInitial:    1,
+		LimitMin:        1,
+		LimitLatency:    time.Second,
+		LimitBackoff:    90,
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+
+	done := make(chan error)
+	go func() {
+		_, err := transFactory.MakeTransHandler().SendCommand(
+			context.Background(),
+			domain.TransCommand{Command: "transinfo"},
+		)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	_, err = transFactory.MakeTransHandler().SendCommand(
+		context.Background(),
+		domain.TransCommand{Command: "transinfo"},
+	)
+	assert.True(t, time.Since(start) < 100*time.Millisecond)
+	assert.Equal(t, transLimitExceeded, err.(domain.TransError).Code)
+	assert.NoError(t, <-done)
+	logger.AssertExpectations(t)
+}
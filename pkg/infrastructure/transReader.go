@@ -0,0 +1,122 @@
+package infrastructure
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// transReader reads a trans response from the connection as it arrives,
+// field by field, and stops on the end line. That way, trans servers that keep
+// the connection open after answering don't stall the request.
+type transReader struct {
+	reader *bufio.Reader
+	// maxResponseSize the maximum number of bytes a response may have. 0 means no limit
+	maxResponseSize int
+	// maxBlobSize the maximum number of bytes a blob value may have. 0 means no limit
+	maxBlobSize int
+	// read the number of bytes read so far
+	read int
+}
+
+// newTransReader returns a transReader reading from reader, with the size
+// limits stated by conf
+func newTransReader(reader *bufio.Reader, conf TransConf) *transReader {
+	return &transReader{
+		reader:          reader,
+		maxResponseSize: conf.MaxResponseSize,
+		maxBlobSize:     conf.MaxBlobSize,
+	}
+}
+
+// readFields reads every field of the response, until the end line
+func (r *transReader) readFields() ([]domain.TransField, error) {
+	fields := make([]domain.TransField, 0)
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(line, []byte("end")) {
+			return fields, nil
+		}
+		var field domain.TransField
+		if bytes.HasPrefix(line, []byte("blob:")) {
+			field, err = r.readBlob(line[len("blob:"):])
+		} else {
+			field, err = parseField(line)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+}
+
+// readLine reads up to the next newline, which is not returned
+func (r *transReader) readLine() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		if err = r.count(len(chunk), err); err != nil && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			return line[:len(line)-1], nil
+		}
+	}
+}
+
+// readBlob reads the value of a blob field, whose header has the form
+// <length>:<key>. The value is followed by a newline
+func (r *transReader) readBlob(header []byte) (domain.TransField, error) {
+	i := bytes.IndexByte(header, ':')
+	if i == -1 {
+		return domain.TransField{}, fmt.Errorf("trans: invalid blob %q", header)
+	}
+	length, err := strconv.Atoi(string(header[:i]))
+	if err != nil || length < 0 {
+		return domain.TransField{}, fmt.Errorf("trans: cannot parse blob length: %q", header[:i])
+	}
+	if r.maxBlobSize > 0 && length > r.maxBlobSize {
+		return domain.TransField{}, fmt.Errorf("trans: blob of %d bytes exceeds the limit of %d", length, r.maxBlobSize)
+	}
+	if err = r.count(length+1, nil); err != nil {
+		return domain.TransField{}, err
+	}
+	value := make([]byte, length+1)
+	if _, err = io.ReadFull(r.reader, value); err != nil {
+		return domain.TransField{}, fmt.Errorf("trans: truncated blob: %s", err)
+	}
+	if value[length] != '\n' {
+		return domain.TransField{}, fmt.Errorf("trans: newline is missing after blob")
+	}
+	return domain.TransField{Key: string(header[i+1:]), Value: string(value[:length]), Blob: true}, nil
+}
+
+// count adds n bytes to the bytes read, checking the response size limit.
+// It also translates err into a meaningful error, if any
+func (r *transReader) count(n int, err error) error {
+	r.read += n
+	if r.maxResponseSize > 0 && r.read > r.maxResponseSize {
+		return fmt.Errorf("trans: response exceeds the limit of %d bytes", r.maxResponseSize)
+	}
+	if err == io.EOF {
+		return fmt.Errorf("trans: connection closed before the end of the response")
+	}
+	return err
+}
+
+// parseField parses a line with the form <key>:<value>
+func parseField(line []byte) (domain.TransField, error) {
+	i := bytes.IndexByte(line, ':')
+	if i == -1 {
+		return domain.TransField{}, fmt.Errorf("trans: invalid key-value format: %q", line)
+	}
+	return domain.TransField{Key: string(line[:i]), Value: string(line[i+1:])}, nil
+}
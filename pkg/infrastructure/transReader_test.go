@@ -0,0 +1,123 @@
+package infrastructure
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func readFields(response string, conf TransConf) ([]domain.TransField, error) {
+	reader := bufio.NewReaderSize(strings.NewReader(response), 16)
+	return newTransReader(reader, conf).readFields()
+}
+
+func TestTransReaderReadFields(t *testing.T) {
+	response := "status:TRANS_OK\nblob:8:image\nend\nx:1\n\nname:a very long value\nend\nignored:1\n"
+	expected := []domain.TransField{
+		{Key: "status", Value: "TRANS_OK"},
+		{Key: "image", Value: "end\nx:1\n", Blob: true},
+		{Key: "name", Value: "a very long value"},
+	}
+
+	fields, err := readFields(response, TransConf{})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, fields)
+}
+
+func TestTransReaderErrors(t *testing.T) {
+	cases := map[string]struct {
+		response string
+		conf     TransConf
+		err      string
+	}{
+		"missing end": {
+			response: "status:TRANS_OK\n",
+			err:      "trans: connection closed before the end of the response",
+		},
+		"truncated blob": {
+			response: "blob:50:image\nabc",
+			err:      "trans: truncated blob: unexpected EOF",
+		},
+		"invalid blob length": {
+			response: "blob:x:image\nabc\nend\n",
+			err:      `trans: cannot parse blob length: "x"`,
+		},
+		"invalid blob": {
+			response: "blob:3\nabc\nend\n",
+			err:      `trans: invalid blob "3"`,
+		},
+		"blob without newline": {
+			response: "blob:2:image\nabc\nend\n",
+			err:      "trans: newline is missing after blob",
+		},
+		"invalid field": {
+			response: "status\nend\n",
+			err:      `trans: invalid key-value format: "status"`,
+		},
+		"blob too large": {
+			response: "blob:3:image\nabc\nend\n",
+			conf:     TransConf{MaxBlobSize: 2},
+			err:      "trans: blob of 3 bytes exceeds the limit of 2",
+		},
+		"response too large": {
+			response: "status:TRANS_OK\nname:a very long value\nend\n",
+			conf:     TransConf{MaxResponseSize: 20},
+			err:      "trans: response exceeds the limit of 20 bytes",
+		},
+		"blob over response limit": {
+			response: "blob:30:image\n",
+			conf:     TransConf{MaxResponseSize: 20},
+			err:      "trans: response exceeds the limit of 20 bytes",
+		},
+	}
+	for name, c := range cases {
+		fields, err := readFields(c.response, c.conf)
+		assert.EqualError(t, err, c.err, name)
+		assert.Nil(t, fields, name)
+	}
+}
+
+func TestSendCommandConnectionKeptOpen(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte(WelcomeMessage))
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+		_, _ = conn.Write([]byte("status:TRANS_OK\n" + EndMessage))
+		// the connection is kept open until the test finishes
+		<-done
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	conf := TransConf{
+		Host:            addr.IP.String(),
+		Port:            addr.Port,
+		Timeout:         5,
+		AllowedCommands: test,
+	}
+	logger := MockLoggerInfrastructure{}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	start := time.Now()
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: "TRANS_OK"}}, resp)
+	assert.True(t, time.Since(start) < time.Second)
+	logger.AssertExpectations(t)
+}
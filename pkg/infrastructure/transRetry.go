@@ -0,0 +1,110 @@
+package infrastructure
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// transRetryPolicy decides which failed commands are sent again, and how long
+// to wait before each retry. Failures that happen before the command reaches
+// trans, like busy greetings and connection errors, are retried for every
+// command. Timeouts, lost connections, invalid responses and the statuses on
+// retryStatuses are retried only for idempotent commands, as trans may have
+// executed them already
+type transRetryPolicy struct {
+	attempts           int
+	backoff            time.Duration
+	maxBackoff         time.Duration
+	jitter             int
+	idempotentCommands []string
+	retryStatuses      []string
+}
+
+// newTransRetryPolicy creates the retry policy configured on conf
+func newTransRetryPolicy(conf TransConf) transRetryPolicy {
+	return transRetryPolicy{
+		attempts:           conf.RetryAttempts,
+		backoff:            conf.RetryBackoff,
+		maxBackoff:         conf.RetryMaxBackoff,
+		jitter:             conf.RetryJitter,
+		idempotentCommands: splitList(conf.IdempotentCommands),
+		retryStatuses:      splitList(conf.RetryStatuses),
+	}
+}
+
+// splitList splits a list of values separated by '|'. An empty string is an empty list
+func splitList(list string) []string {
+	if list == "" {
+		return nil
+	}
+	return strings.Split(list, "|")
+}
+
+// isIdempotent checks if the command can be executed more than once safely
+func (p transRetryPolicy) isIdempotent(cmd string) bool {
+	for _, idempotentCommand := range p.idempotentCommands {
+		if idempotentCommand == cmd {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable checks if the command should be sent again after the given
+// attempt, that returned resp and err
+func (p transRetryPolicy) retryable(cmd string, retry int, resp []domain.TransField, err error) bool {
+	if retry >= p.attempts {
+		return false
+	}
+	switch e := err.(type) {
+	case domain.BusyError:
+		return true
+	case domain.TransError:
+		if e.Class == domain.ErrorClassUpstream && e.Code == transUnavailable {
+			return true
+		}
+		return p.isIdempotent(cmd) &&
+			(e.Class == domain.ErrorClassUpstream || e.Class == domain.ErrorClassTimeout)
+	case nil:
+		return p.isIdempotent(cmd) && p.hasRetryStatus(resp)
+	}
+	return false
+}
+
+// hasRetryStatus checks if the status of the response starts with any of retryStatuses
+func (p transRetryPolicy) hasRetryStatus(resp []domain.TransField) bool {
+	for _, field := range resp {
+		if field.Key != "status" {
+			continue
+		}
+		for _, status := range p.retryStatuses {
+			if strings.HasPrefix(field.Value, status) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait before the given retry. The backoff doubles
+// on each retry up to maxBackoff, and up to jitter percent of it is taken
+// away at random, so retries from several requests don't line up
+func (p transRetryPolicy) delay(retry int) time.Duration {
+	delay := p.backoff
+	for i := 0; i < retry && (p.maxBackoff == 0 || delay < p.maxBackoff); i++ {
+		delay *= 2
+	}
+	if p.maxBackoff > 0 && delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	if p.jitter > 0 && delay > 0 {
+		spread := int64(delay) * int64(p.jitter) / 100
+		if spread > 0 {
+			delay -= time.Duration(rand.Int63n(spread)) // nolint: gosec
+		}
+	}
+	return delay
+}
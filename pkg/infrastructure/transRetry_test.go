@@ -0,0 +1,137 @@
+package infrastructure
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestTransRetryPolicyRetryable(t *testing.T) {
+	policy := newTransRetryPolicy(TransConf{
+		RetryAttempts:      2,
+		IdempotentCommands: "transinfo|get_account",
+		RetryStatuses:      "TRANS_DATABASE_ERROR",
+	})
+	dbError := []domain.TransField{{Key: "status", Value: "TRANS_DATABASE_ERROR:deadlock"}}
+	timeout := domain.TransError{Class: domain.ErrorClassTimeout, Code: transTimeout}
+	badResponse := upstreamError(transBadResponse, "error parsing response")
+	unreachable := upstreamError(transUnavailable, "Error connecting with trans server")
+	unavailable := domain.TransError{Class: domain.ErrorClassUnavailable, Code: transUnavailable}
+
+	cases := []struct {
+		name     string
+		cmd      string
+		retry    int
+		resp     []domain.TransField
+		err      error
+		expected bool
+	}{
+		{name: "busy", cmd: "newad", err: domain.BusyError{}, expected: true},
+		{name: "unreachable", cmd: "newad", err: unreachable, expected: true},
+		{name: "no retries left", cmd: "newad", retry: 2, err: domain.BusyError{}},
+		{name: "breaker open", cmd: "transinfo", err: unavailable},
+		{name: "timeout", cmd: "transinfo", err: timeout, expected: true},
+		{name: "timeout not idempotent", cmd: "newad", err: timeout},
+		{name: "bad response", cmd: "get_account", err: badResponse, expected: true},
+		{name: "bad response not idempotent", cmd: "bump_ad", err: badResponse},
+		{name: "database error", cmd: "get_account", resp: dbError, expected: true},
+		{name: "database error not idempotent", cmd: "bump_ad", resp: dbError},
+		{name: "ok", cmd: "get_account", resp: []domain.TransField{{Key: "status", Value: transOK}}},
+		{name: "other error", cmd: "get_account", err: errors.New("invalid command")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expected, policy.retryable(c.cmd, c.retry, c.resp, c.err))
+		})
+	}
+}
+
+func TestTransRetryPolicyDelay(t *testing.T) {
+	policy := newTransRetryPolicy(TransConf{RetryBackoff: 100 * time.Millisecond, RetryMaxBackoff: 300 * time.Millisecond})
+	assert.Equal(t, 100*time.Millisecond, policy.delay(0))
+	assert.Equal(t, 200*time.Millisecond, policy.delay(1))
+	assert.Equal(t, 300*time.Millisecond, policy.delay(2))
+	assert.Equal(t, 300*time.Millisecond, policy.delay(40))
+}
+
+func TestTransRetryPolicyDelayJitter(t *testing.T) {
+	policy := newTransRetryPolicy(TransConf{RetryBackoff: 100 * time.Millisecond, RetryJitter: 50})
+	for i := 0; i < 100; i++ {
+		delay := policy.delay(1)
+		assert.True(t, delay > 100*time.Millisecond && delay <= 200*time.Millisecond, delay)
+	}
+}
+
+func TestSendCommandRetriesIdempotentCommands(t *testing.T) {
+	var calls int32
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return []byte("status:TRANS_DATABASE_ERROR:deadlock detected\n")
+		}
+		return []byte("status:TRANS_OK\n")
+	})
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug").Once()
+	conf := TransConf{
+		Backends:           server.Address,
+		Timeout:            15,
+		AllowedCommands:    "get_account|bump_ad",
+		RetryAttempts:      2,
+		RetryBackoff:       10 * time.Millisecond,
+		IdempotentCommands: "get_account",
+		RetryStatuses:      "TRANS_DATABASE_ERROR",
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+
+	handler := transFactory.MakeTransHandler()
+	resp, err := handler.SendCommand(context.Background(), domain.TransCommand{Command: "get_account"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}}, resp)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// bump_ad is not idempotent, so it is sent only once
+	atomic.StoreInt32(&calls, 0)
+	resp, err = handler.SendCommand(context.Background(), domain.TransCommand{Command: "bump_ad"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: "TRANS_DATABASE_ERROR:deadlock detected"}}, resp)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandRetriesTimedOutAttempts(t *testing.T) {
+	var calls int32
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(func(input []byte) []byte {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			time.Sleep(300 * time.Millisecond)
+		}
+		return []byte("status:TRANS_OK\n")
+	})
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug").Once()
+	conf := TransConf{
+		Backends:           server.Address,
+		Timeout:            15,
+		AttemptTimeout:     100 * time.Millisecond,
+		AllowedCommands:    "transinfo",
+		RetryAttempts:      1,
+		IdempotentCommands: "transinfo",
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+
+	handler := transFactory.MakeTransHandler()
+	resp, err := handler.SendCommand(context.Background(), domain.TransCommand{Command: "transinfo"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}}, resp)
+	logger.AssertExpectations(t)
+}
@@ -0,0 +1,140 @@
+package infrastructure
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/interfaces/loggers"
+)
+
+// tlsVersions the TLS versions that can be set as TLSMinVersion
+var tlsVersions = map[string]uint16{ // nolint: gochecknoglobals
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// transTLS builds the TLS configuration used to connect to trans. The CA
+// bundle and the client certificate are read again whenever their files
+// change, so they can be renewed without a restart. If the new files can't be
+// loaded, the previous configuration is kept
+type transTLS struct {
+	caFile     string
+	certFile   string
+	keyFile    string
+	serverName string
+	minVersion uint16
+	logger     loggers.Logger
+
+	mutex   sync.Mutex
+	config  *tls.Config
+	modTime map[string]time.Time
+}
+
+// newTransTLS creates the TLS configuration on conf, or nil if TLS is disabled
+func newTransTLS(conf TransConf, logger loggers.Logger) (*transTLS, error) {
+	if !conf.TLS {
+		return nil, nil
+	}
+	minVersion, ok := tlsVersions[conf.TLSMinVersion]
+	if !ok {
+		return nil, fmt.Errorf("unknown TLS version %q", conf.TLSMinVersion)
+	}
+	if (conf.TLSCertFile == "") != (conf.TLSKeyFile == "") {
+		return nil, fmt.Errorf("both the TLS client certificate and key must be set")
+	}
+	// unix sockets have no host to verify the certificate of trans against
+	if conf.TLSServerName == "" {
+		backends, _ := parseTransBackends(conf)
+		for _, backend := range backends {
+			if network, _, _ := parseEndpoint(backend.address); network == "unix" {
+				return nil, fmt.Errorf("the TLS server name must be set to reach trans on %s", backend.address)
+			}
+		}
+	}
+	t := &transTLS{
+		caFile:     conf.TLSCAFile,
+		certFile:   conf.TLSCertFile,
+		keyFile:    conf.TLSKeyFile,
+		serverName: conf.TLSServerName,
+		minVersion: minVersion,
+		logger:     logger,
+	}
+	if err := t.load(t.modTimes()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// clientConfig returns the configuration to connect to the trans at host,
+// reloading the files first if they changed
+func (t *transTLS) clientConfig(host string) *tls.Config {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	modTime := t.modTimes()
+	for file, mod := range modTime {
+		if !mod.Equal(t.modTime[file]) {
+			if err := t.load(modTime); err != nil {
+				t.logger.Warn("Error reloading trans TLS files, keeping the previous ones: %s\n", err)
+			} else {
+				t.logger.Info("Trans TLS files reloaded\n")
+			}
+			break
+		}
+	}
+	config := t.config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	return config
+}
+
+// modTimes returns when each file was last modified
+func (t *transTLS) modTimes() map[string]time.Time {
+	modTime := make(map[string]time.Time)
+	for _, file := range []string{t.caFile, t.certFile, t.keyFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			modTime[file] = info.ModTime()
+		}
+	}
+	return modTime
+}
+
+// load reads the files into a new configuration. modTime is kept, so the
+// files aren't read again until they change. Failed loads are retried once
+// the files change again
+func (t *transTLS) load(modTime map[string]time.Time) error {
+	t.modTime = modTime
+	config := &tls.Config{
+		ServerName: t.serverName,
+		MinVersion: t.minVersion,
+	}
+	if t.caFile != "" {
+		pem, err := ioutil.ReadFile(t.caFile)
+		if err != nil {
+			return err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found on %s", t.caFile)
+		}
+	}
+	if t.certFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	t.config = config
+	return nil
+}
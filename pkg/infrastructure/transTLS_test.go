@@ -0,0 +1,238 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// testCert is a certificate issued for the tests, along with its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert issues a certificate for name. Without a parent, it's a self-signed CA
+func newTestCert(t *testing.T, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(name); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{name}
+	}
+	signer := &testCert{cert: template, key: key}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// tlsCertificate returns the certificate to be used on a tls.Config
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// writeFiles writes the certificate and its key as PEM files on dir, returning their paths
+func (c *testCert) writeFiles(t *testing.T, dir, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	key, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600))
+	assert.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+	return certFile, keyFile
+}
+
+// newTLSServer starts a mock trans that requires client certificates signed by ca
+func newTLSServer(t *testing.T, ca *testCert) *MockTransServer {
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	server := NewMockTLSTransServer(&tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "127.0.0.1", ca).tlsCertificate()},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+	return server
+}
+
+func TestNewTransTLSInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	caFile, keyFile := newTestCert(t, "ca", nil).writeFiles(t, dir, "ca")
+
+	for name, conf := range map[string]TransConf{
+		"version":     {TLS: true, TLSMinVersion: "2.0"},
+		"missing key": {TLS: true, TLSMinVersion: "1.2", TLSCertFile: caFile},
+		"missing ca":  {TLS: true, TLSMinVersion: "1.2", TLSCAFile: filepath.Join(dir, "none")},
+		"invalid ca":  {TLS: true, TLSMinVersion: "1.2", TLSCAFile: keyFile},
+		"unix":        {TLS: true, TLSMinVersion: "1.2", Endpoint: "unix:///tmp/trans.sock"},
+		"unix backend": {
+			TLS: true, TLSMinVersion: "1.2", Backends: "localhost:5656|unix:///tmp/trans.sock",
+		},
+	} {
+		_, err := newTransTLS(conf, nil)
+		assert.Error(t, err, name)
+	}
+	_, err = NewTextProtocolTransFactory(TransConf{
+		Charset:       "UTF-8",
+		TLS:           true,
+		TLSMinVersion: "1.2",
+		Endpoint:      "unix:///tmp/trans.sock",
+	}, nil, nil)
+	assert.EqualError(t, err, "the TLS server name must be set to reach trans on unix:///tmp/trans.sock")
+	// with a server name, trans can be verified on unix sockets too
+	tlsConf, err := newTransTLS(TransConf{
+		TLS:           true,
+		TLSMinVersion: "1.2",
+		TLSServerName: "trans",
+		Endpoint:      "unix:///tmp/trans.sock",
+	}, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, tlsConf)
+	tlsConf, err = newTransTLS(TransConf{}, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, tlsConf)
+}
+
+func TestSendCommandMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "proxy", ca).writeFiles(t, dir, "client")
+	server := newTLSServer(t, ca)
+	defer server.Close()
+
+	logger := MockLoggerInfrastructure{}
+	conf := TransConf{
+		Backends:        server.Address,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+		TLS:             true,
+		TLSCAFile:       caFile,
+		TLSCertFile:     certFile,
+		TLSKeyFile:      keyFile,
+		TLSMinVersion:   "1.2",
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	resp, err := transFactory.MakeTransHandler().SendCommand(
+		context.Background(),
+		domain.TransCommand{Command: "transinfo"},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: transOK}}, resp)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandTLSUntrustedServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	ca := newTestCert(t, "ca", nil)
+	// the proxy trusts another CA
+	caFile, _ := newTestCert(t, "other", nil).writeFiles(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "proxy", ca).writeFiles(t, dir, "client")
+	server := newTLSServer(t, ca)
+	defer server.Close()
+
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	conf := TransConf{
+		Backends:        server.Address,
+		Timeout:         15,
+		AllowedCommands: "transinfo",
+		TLS:             true,
+		TLSCAFile:       caFile,
+		TLSCertFile:     certFile,
+		TLSKeyFile:      keyFile,
+		TLSMinVersion:   "1.2",
+	}
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	_, err = transFactory.MakeTransHandler().SendCommand(
+		context.Background(),
+		domain.TransCommand{Command: "transinfo"},
+	)
+	assert.Equal(t, transUnavailable, err.(domain.TransError).Code)
+	logger.AssertExpectations(t)
+}
+
+func TestTransTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trans")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir) // nolint: errcheck
+	ca := newTestCert(t, "ca", nil)
+	certFile, keyFile := newTestCert(t, "first", ca).writeFiles(t, dir, "client")
+
+	logger := MockLoggerInfrastructure{}
+	logger.On("Info").Once()
+	logger.On("Warn").Once()
+	tlsConf, err := newTransTLS(TransConf{
+		TLS:           true,
+		TLSCertFile:   certFile,
+		TLSKeyFile:    keyFile,
+		TLSMinVersion: "1.3",
+	}, &logger)
+	assert.NoError(t, err)
+	config := tlsConf.clientConfig("trans")
+	assert.Equal(t, "trans", config.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	first := config.Certificates[0].Certificate[0]
+
+	// a renewed certificate is used on the next connection
+	second := newTestCert(t, "second", ca)
+	second.writeFiles(t, dir, "client")
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, future, future))
+	config = tlsConf.clientConfig("trans")
+	assert.NotEqual(t, first, config.Certificates[0].Certificate[0])
+	assert.Equal(t, second.der, config.Certificates[0].Certificate[0])
+
+	// but broken files are ignored
+	assert.NoError(t, ioutil.WriteFile(certFile, []byte("broken"), 0600))
+	future = future.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(certFile, future, future))
+	config = tlsConf.clientConfig("trans")
+	assert.Equal(t, second.der, config.Certificates[0].Certificate[0])
+	logger.AssertExpectations(t)
+}
@@ -1,6 +1,7 @@
 package infrastructure
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"strings"
@@ -39,13 +40,13 @@ func TestSendCommandInvalidCommand(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
 	logger.On("Error")
-	expectedResponse := map[string]string{}
-	expectedResponse["error"] = "Invalid Command. Valid commands: [test]"
+	expectedResponse := []domain.TransField{
+		{Key: "error", Value: "invalid command - commands allowed: [test]"},
+	}
 	cmd := "transinfo"
 	params := []domain.TransParams{
 		{
@@ -54,10 +55,43 @@ func TestSendCommandInvalidCommand(t *testing.T) {
 		},
 	}
 
-	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
+	assert.Error(t, err)
+	assert.Equal(t, expectedResponse, resp)
+	logger.AssertExpectations(t)
+}
+
+func TestIsDryRunCommand(t *testing.T) {
+	transHandler := trans{
+		dryRunCommands: []string{"newad", "create_account"},
+	}
+
+	assert.True(t, transHandler.isDryRunCommand("newad"))
+	assert.True(t, transHandler.isDryRunCommand("create_account"))
+	assert.False(t, transHandler.isDryRunCommand("bump_ad"))
+}
+
+func TestSendCommandDryRunNotAllowed(t *testing.T) {
+	conf := TransConf{
+		Timeout:         15,
+		AllowedCommands: "newad|bump_ad",
+		DryRunCommands:  "newad",
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	expectedResponse := []domain.TransField{
+		{Key: "error", Value: "dry run is not allowed for command bump_ad"},
+	}
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: "bump_ad", DryRun: true})
 	assert.Error(t, err)
 	assert.Equal(t, expectedResponse, resp)
 	logger.AssertExpectations(t)
@@ -84,12 +118,11 @@ func TestSendCommandTimeout(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         1,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
 	logger.On("Error")
-	var expectedResponse map[string]string
+	var expectedResponse []domain.TransField
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -98,10 +131,12 @@ func TestSendCommandTimeout(t *testing.T) {
 		},
 	}
 
-	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
 	transHandler := transFactory.MakeTransHandler()
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.Error(t, err)
+	assert.Equal(t, domain.ErrorClassTimeout, err.(domain.TransError).Class)
 	assert.Equal(t, expectedResponse, resp)
 	logger.AssertExpectations(t)
 }
@@ -118,12 +153,11 @@ func TestSendCommandBusyServer(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
 	logger.On("Error")
-	var expectedResponse map[string]string
+	var expectedResponse []domain.TransField
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -132,10 +166,11 @@ func TestSendCommandBusyServer(t *testing.T) {
 		},
 	}
 
-	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.Error(t, err)
 	assert.Equal(t, expectedResponse, resp)
 	logger.AssertExpectations(t)
@@ -160,12 +195,12 @@ func TestSendCommandOK(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
-	expectedResponse := make(map[string]string)
-	expectedResponse["status"] = usecases.TransOK
+	expectedResponse := []domain.TransField{
+		{Key: "status", Value: usecases.TransOK},
+	}
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -174,10 +209,11 @@ func TestSendCommandOK(t *testing.T) {
 		},
 	}
 
-	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResponse, resp)
 	logger.AssertExpectations(t)
@@ -202,12 +238,12 @@ func TestSendCommandBlobOK(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
-	expectedResponse := make(map[string]string)
-	expectedResponse["status"] = usecases.TransOK
+	expectedResponse := []domain.TransField{
+		{Key: "status", Value: usecases.TransOK},
+	}
 	cmd := test
 	params := []domain.TransParams{
 		{
@@ -217,10 +253,11 @@ func TestSendCommandBlobOK(t *testing.T) {
 		},
 	}
 
-	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResponse, resp)
 	logger.AssertExpectations(t)
@@ -241,7 +278,6 @@ func TestISO8859Input(t *testing.T) {
 		Host:            host,
 		Port:            port,
 		Timeout:         15,
-		RetryAfter:      5,
 		AllowedCommands: test,
 	}
 	logger := MockLoggerInfrastructure{}
@@ -253,11 +289,233 @@ func TestISO8859Input(t *testing.T) {
 		},
 	}
 
-	transFactory := NewTextProtocolTransFactory(conf, &logger)
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
 	transHandler := transFactory.MakeTransHandler()
 
-	resp, err := transHandler.SendCommand(cmd, params)
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: cmd, Params: params})
 	assert.NoError(t, err)
-	assert.Equal(t, map[string]string{}, resp)
+	assert.Equal(t, []domain.TransField{}, resp)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandRepeatedKeys(t *testing.T) {
+	response := "status:TRANS_OK\npack_id:1\ntype:car\npack_id:2\ntype:inmo\n"
+
+	handlerFunc := func(input []byte) []byte {
+		return []byte(response)
+	}
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(handlerFunc)
+
+	addr := strings.Split(server.Address, ":")
+	host := addr[0]
+	port, _ := strconv.Atoi(addr[1])
+	conf := TransConf{
+		Host:            host,
+		Port:            port,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+	logger := MockLoggerInfrastructure{}
+	expectedResponse := []domain.TransField{
+		{Key: "status", Value: usecases.TransOK},
+		{Key: "pack_id", Value: "1"},
+		{Key: "type", Value: "car"},
+		{Key: "pack_id", Value: "2"},
+		{Key: "type", Value: "inmo"},
+	}
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedResponse, resp)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBlobResponse(t *testing.T) {
+	response := "status:TRANS_OK\nblob:5:image\n\xff\xd8\n\xe1\x00\nname:foto\xe1\n"
+
+	handlerFunc := func(input []byte) []byte {
+		return []byte(response)
+	}
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(handlerFunc)
+
+	addr := strings.Split(server.Address, ":")
+	host := addr[0]
+	port, _ := strconv.Atoi(addr[1])
+	conf := TransConf{
+		Host:            host,
+		Port:            port,
+		Timeout:         15,
+		AllowedCommands: test,
+	}
+	logger := MockLoggerInfrastructure{}
+	expectedResponse := []domain.TransField{
+		{Key: "status", Value: usecases.TransOK},
+		{Key: "image", Value: "\xff\xd8\n\xe1\x00", Blob: true},
+		{Key: "name", Value: "fotoá"},
+	}
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.NoError(t, err)
+	assert.Equal(t, expectedResponse, resp)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandDecodingError(t *testing.T) {
+	response := "status:TRANS_OK\nname:ok\xc1\n"
+
+	handlerFunc := func(input []byte) []byte {
+		assert.Equal(t, "cmd:test\nsubject:“Auto”\ncommit:1\nend\n", string(input))
+		return []byte(response)
+	}
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetHandler(handlerFunc)
+
+	addr := strings.Split(server.Address, ":")
+	host := addr[0]
+	port, _ := strconv.Atoi(addr[1])
+	conf := TransConf{
+		Host:            host,
+		Port:            port,
+		Timeout:         15,
+		AllowedCommands: test,
+		Charset:         "UTF-8",
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error")
+	params := []domain.TransParams{
+		{Key: "subject", Value: "“Auto”"},
+	}
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: test, Params: params})
+	assert.EqualError(t, err, "error decoding response: name: invalid UTF-8 text \"ok\\xc1\"")
+	assert.Nil(t, resp)
+	logger.AssertExpectations(t)
+}
+
+func TestNewTextProtocolTransFactoryInvalidCharset(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	_, err := NewTextProtocolTransFactory(TransConf{Charset: "EBCDIC"}, &logger, nil)
+	assert.Error(t, err)
+}
+
+func TestNewTextProtocolTransFactoryDeprecatedRetry(t *testing.T) {
+	logger := MockLoggerInfrastructure{}
+	logger.On("Warn").Once()
+	_, err := NewTextProtocolTransFactory(TransConf{Host: "localhost", Port: 20005, RetryAfter: 5}, &logger, nil)
+	assert.NoError(t, err)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBusyRetries(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetBusy(true)
+
+	addr := strings.Split(server.Address, ":")
+	host := addr[0]
+	port, _ := strconv.Atoi(addr[1])
+	conf := TransConf{
+		Host:            host,
+		Port:            port,
+		Timeout:         15,
+		AllowedCommands: test,
+		RetryAttempts:   2,
+		RetryBackoff:    10 * time.Millisecond,
+		BusyRetryAfter:  3,
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug").Twice()
+	logger.On("Error").Once()
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.Equal(t, domain.BusyError{RetryAfter: 3 * time.Second}, err)
+	assert.Nil(t, resp)
+	logger.AssertExpectations(t)
+}
+
+func TestSendCommandBusyThenAvailable(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetBusy(true)
+	server.SetHandler(func(input []byte) []byte {
+		return []byte("status:TRANS_OK\n")
+	})
+
+	addr := strings.Split(server.Address, ":")
+	host := addr[0]
+	port, _ := strconv.Atoi(addr[1])
+	conf := TransConf{
+		Host:            host,
+		Port:            port,
+		Timeout:         15,
+		AllowedCommands: test,
+		RetryAttempts:   5,
+		RetryBackoff:    100 * time.Millisecond,
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Debug")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		server.SetBusy(false)
+	}()
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	resp, err := transHandler.SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.TransField{{Key: "status", Value: usecases.TransOK}}, resp)
+}
+
+func TestSendCommandBusyRetryBeyondDeadline(t *testing.T) {
+	server := NewMockTransServer()
+	defer server.Close()
+	server.SetBusy(true)
+
+	addr := strings.Split(server.Address, ":")
+	host := addr[0]
+	port, _ := strconv.Atoi(addr[1])
+	conf := TransConf{
+		Host:            host,
+		Port:            port,
+		Timeout:         1,
+		AllowedCommands: test,
+		RetryAttempts:   3,
+		RetryBackoff:    2000 * time.Millisecond,
+	}
+	logger := MockLoggerInfrastructure{}
+	logger.On("Error").Once()
+
+	transFactory, err := NewTextProtocolTransFactory(conf, &logger, nil)
+	assert.NoError(t, err)
+	transHandler := transFactory.MakeTransHandler()
+
+	start := time.Now()
+	_, err = transHandler.SendCommand(context.Background(), domain.TransCommand{Command: test})
+	assert.IsType(t, domain.BusyError{}, err)
+	assert.True(t, time.Since(start) < time.Second)
 	logger.AssertExpectations(t)
 }
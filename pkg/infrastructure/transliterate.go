@@ -0,0 +1,57 @@
+package infrastructure
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// approximations maps common characters missing on single byte charsets
+// to a close representation
+var approximations = map[rune]string{ // nolint: gochecknoglobals
+	'‘': "'", '’': "'", '‚': "'", '‛': "'", '′': "'",
+	'“': `"`, '”': `"`, '„': `"`, '‟': `"`, '″': `"`,
+	'‹': "<", '›': ">",
+	'‐': "-", '‑': "-", '‒': "-", '–': "-", '—': "-", '―': "-", '−': "-",
+	'Ł': "L", 'ł': "l", 'Đ': "D", 'đ': "d", 'Œ': "OE", 'œ': "oe",
+	'…': "...", '•': "*", '€': "EUR", '™': "TM", '\u200b': "",
+}
+
+// transliterate replaces every character of s that the charset can not
+// encode with an approximation: a known replacement, the character without
+// its accents, or '?' when nothing better is available
+func transliterate(s string, charset transCharset) string {
+	var b strings.Builder
+	for _, r := range s {
+		if charset.canEncode(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if replacement, ok := approximations[r]; ok {
+			b.WriteString(replacement)
+			continue
+		}
+		b.WriteString(stripAccents(r, charset))
+	}
+	return b.String()
+}
+
+// stripAccents decomposes r and keeps its base characters, if the charset
+// can encode them
+func stripAccents(r rune, charset transCharset) string {
+	var b strings.Builder
+	for _, d := range norm.NFD.String(string(r)) {
+		if unicode.Is(unicode.Mn, d) {
+			continue
+		}
+		if !charset.canEncode(d) {
+			return "?"
+		}
+		b.WriteRune(d)
+	}
+	if b.Len() == 0 {
+		return "?"
+	}
+	return b.String()
+}
@@ -28,6 +28,13 @@ type Handler interface {
 	Execute(InputGetter) *goutils.Response
 }
 
+// HeaderedBody is a response Body that also needs to set http headers on the
+// response. Only Body is written as json
+type HeaderedBody struct {
+	Body    interface{}
+	Headers http.Header
+}
+
 // MakeJSONHandlerFunc wraps a Handler on a json-over-http context, returning
 // a standard http.HandlerFunc
 func MakeJSONHandlerFunc(h Handler, l JSONHandlerLogger) http.HandlerFunc {
@@ -61,9 +68,8 @@ func (jh *jsonHandler) run(w http.ResponseWriter, r *http.Request) {
 	// Function the request can call to retrieve its input
 	inputGetter := func() (HandlerInput, *goutils.Response) {
 		input := jh.handler.Input()
-		// Parse the get params
-		getParams := mux.Vars(r)
-		response = fillGet(getParams, input)
+		// Parse the get, query and header params
+		response = fillTags(r, input)
 		if response != nil {
 			return input, response
 		}
@@ -74,6 +80,12 @@ func (jh *jsonHandler) run(w http.ResponseWriter, r *http.Request) {
 	}
 	// Format the output and send it down the writer
 	outputWriter := func() {
+		if body, ok := response.Body.(HeaderedBody); ok {
+			for key, values := range body.Headers {
+				w.Header()[key] = values
+			}
+			response.Body = body.Body
+		}
 		goutils.CreateJSON(response)
 		goutils.WriteJSONResponse(w, response)
 	}
@@ -91,17 +103,32 @@ func (jh *jsonHandler) run(w http.ResponseWriter, r *http.Request) {
 	jh.logger.LogRequestEnd(r, response)
 }
 
-// fillGet set variables into the corresponding get param
-func fillGet(vars map[string]string, input interface{}) *goutils.Response {
+// fillTags set variables into the corresponding get, query or header param.
+// get tags are read from the route vars, query tags from the url query string
+// and header tags from the request headers. request tags get the context
+// ("context"), the remote address ("remote_addr") or every header ("headers")
+// of the request
+func fillTags(r *http.Request, input interface{}) *goutils.Response {
 	v := reflect.ValueOf(input)
 	reflectedInput := reflect.Indirect(v)
 	// Only attempt to set writeable variables
 	if reflectedInput.IsValid() && reflectedInput.CanSet() && reflectedInput.Kind() == reflect.Struct {
-		// Recursively load inner struct fields
+		vars := mux.Vars(r)
+		query := r.URL.Query()
 		for i := 0; i < reflectedInput.NumField(); i++ {
-			if tag, ok := reflectedInput.Type().Field(i).Tag.Lookup("get"); ok {
+			field := reflectedInput.Type().Field(i)
+			if tag, ok := field.Tag.Lookup("get"); ok {
 				reflectedInput.Field(i).Set(reflect.ValueOf(vars[tag]))
 			}
+			if tag, ok := field.Tag.Lookup("query"); ok {
+				reflectedInput.Field(i).Set(reflect.ValueOf(query.Get(tag)))
+			}
+			if tag, ok := field.Tag.Lookup("header"); ok {
+				reflectedInput.Field(i).Set(reflect.ValueOf(r.Header.Get(tag)))
+			}
+			if tag, ok := field.Tag.Lookup("request"); ok {
+				fillRequestTag(r, tag, reflectedInput.Field(i))
+			}
 		}
 		return nil
 	}
@@ -112,3 +139,15 @@ func fillGet(vars map[string]string, input interface{}) *goutils.Response {
 		},
 	}
 }
+
+// fillRequestTag sets the field to the part of the request named by tag
+func fillRequestTag(r *http.Request, tag string, field reflect.Value) {
+	switch tag {
+	case "context":
+		field.Set(reflect.ValueOf(r.Context()))
+	case "remote_addr":
+		field.Set(reflect.ValueOf(r.RemoteAddr))
+	case "headers":
+		field.Set(reflect.ValueOf(r.Header))
+	}
+}
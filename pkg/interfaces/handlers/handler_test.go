@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -9,6 +10,7 @@ import (
 	"github.com/Yapo/goutils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	mux "gopkg.in/gorilla/mux.v1"
 )
 
 type MockHandler struct {
@@ -64,6 +66,15 @@ type DummyInputGet struct {
 	X      int
 }
 
+type DummyInputTags struct {
+	Method     string          `get:"method"`
+	Query      string          `query:"q"`
+	Header     string          `header:"X-Test"`
+	Context    context.Context `request:"context"`
+	RemoteAddr string          `request:"remote_addr"`
+	Headers    http.Header     `request:"headers"`
+}
+
 type DummyOutput struct {
 	Y string
 }
@@ -121,6 +132,24 @@ func TestJsonHandlerFillGet(t *testing.T) {
 	l.AssertExpectations(t)
 }
 
+func TestFillTags(t *testing.T) {
+	input := &DummyInputTags{}
+	r := httptest.NewRequest("GET", "/someurl?q=query", nil)
+	r = mux.SetURLVars(r, map[string]string{"method": "get"})
+	r.Header.Set("X-Test", "header")
+
+	response := fillTags(r, input)
+	assert.Nil(t, response)
+	assert.Equal(t, &DummyInputTags{
+		Method:     "get",
+		Query:      "query",
+		Header:     "header",
+		Context:    r.Context(),
+		RemoteAddr: r.RemoteAddr,
+		Headers:    r.Header,
+	}, input)
+}
+
 func TestJsonHandlerFillGetInvalidStruct(t *testing.T) {
 	h := MockHandler{}
 	l := MockLogger{}
@@ -194,3 +223,34 @@ func TestJsonHandlerFuncPanic(t *testing.T) {
 	h.AssertExpectations(t)
 	l.AssertExpectations(t)
 }
+
+func TestJsonHandlerFuncHeaderedBody(t *testing.T) {
+	h := MockHandler{}
+	l := MockLogger{}
+	input := &DummyInput{}
+	response := &goutils.Response{
+		Code: http.StatusServiceUnavailable,
+		Body: HeaderedBody{
+			Body:    DummyOutput{"busy"},
+			Headers: http.Header{"Retry-After": []string{"1"}},
+		},
+	}
+	getter := mock.AnythingOfType("handlers.InputGetter")
+	h.On("Execute", getter).Return(response).Once()
+	h.On("Input").Return(input).Once()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/someurl", strings.NewReader("{}"))
+
+	l.On("LogRequestStart", r)
+	l.On("LogRequestEnd", r, response)
+
+	fn := MakeJSONHandlerFunc(&h, &l)
+	fn(w, r)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+	assert.Equal(t, `{"Y":"busy"}`+"\n", w.Body.String())
+	h.AssertExpectations(t)
+	l.AssertExpectations(t)
+}
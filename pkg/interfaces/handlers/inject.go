@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// InjectionSource is where the value of an injected param is taken from
+type InjectionSource string
+
+const (
+	// InjectClientIP the IP of the caller, behind the trusted proxies
+	InjectClientIP InjectionSource = "client_ip"
+	// InjectHeader a header of the request
+	InjectHeader InjectionSource = "header"
+	// InjectStatic a fixed value
+	InjectStatic InjectionSource = "static"
+	// InjectClient the name of the authenticated client
+	InjectClient InjectionSource = "client"
+)
+
+// ParamInjection sets a param of a command on the server side, so callers
+// don't have to, or can't, send it
+type ParamInjection struct {
+	Param  string
+	Source InjectionSource
+	// Header the header the value is taken from, for InjectHeader
+	Header string
+	// Value the value, for InjectStatic
+	Value string
+	// Override replaces the value sent by the caller, even when there is
+	// nothing to inject. Otherwise the value is only injected if the caller didn't send one
+	Override bool
+}
+
+// clientKey is the context key of the authenticated client
+type clientKey struct{}
+
+// WithClient returns a copy of ctx that carries the name of the authenticated client
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// ClientFrom returns the name of the authenticated client carried by ctx, if any
+func ClientFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	client, _ := ctx.Value(clientKey{}).(string)
+	return client
+}
+
+// inject applies the injections of the command, and the ones of every
+// command, listed under "*", to its params
+func (t *TransHandler) inject(in *TransHandlerInput, command domain.TransCommand) []domain.TransParams {
+	injections := make([]ParamInjection, 0, len(t.Injections["*"])+len(t.Injections[command.Command]))
+	injections = append(injections, t.Injections["*"]...)
+	injections = append(injections, t.Injections[command.Command]...)
+	params := command.Params
+	for _, injection := range injections {
+		if !injection.Override && hasParam(params, injection.Param) {
+			continue
+		}
+		value := t.injectedValue(in, injection)
+		if value == "" && !injection.Override {
+			continue
+		}
+		params = withoutParam(params, injection.Param)
+		if value != "" {
+			params = append(params, domain.TransParams{Key: injection.Param, Value: value})
+		}
+	}
+	return params
+}
+
+// injectedValue returns the value of the injection for the request, or "" if there is none
+func (t *TransHandler) injectedValue(in *TransHandlerInput, injection ParamInjection) string {
+	switch injection.Source {
+	case InjectClientIP:
+		return clientIP(in.RemoteAddr, in.Headers, t.TrustedProxies)
+	case InjectHeader:
+		return in.Headers.Get(injection.Header)
+	case InjectStatic:
+		return injection.Value
+	case InjectClient:
+		return ClientFrom(in.Context)
+	}
+	return ""
+}
+
+// clientIP returns the IP of the caller. If the request came through trusted
+// proxies, it's the last address on X-Forwarded-For that isn't a trusted proxy
+func clientIP(remoteAddr string, headers http.Header, trusted []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	var forwarded []string
+	for _, value := range headers["X-Forwarded-For"] {
+		for _, addr := range strings.Split(value, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0 && isTrusted(ip, trusted); i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			break
+		}
+		ip = forwarded[i]
+	}
+	return ip
+}
+
+// isTrusted checks if ip belongs to any of the trusted networks
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	for _, network := range trusted {
+		if parsed != nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasParam checks if the caller sent a value for the param
+func hasParam(params []domain.TransParams, key string) bool {
+	for _, param := range params {
+		if param.Key == key && param.Value != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutParam returns a copy of params without the ones with the given key
+func withoutParam(params []domain.TransParams, key string) []domain.TransParams {
+	kept := make([]domain.TransParams, 0, len(params))
+	for _, param := range params {
+		if param.Key != key {
+			kept = append(kept, param)
+		}
+	}
+	return kept
+}
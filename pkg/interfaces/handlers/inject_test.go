@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestClientIP(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+	cases := map[string]struct {
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		"direct":            {"1.2.3.4:5000", nil, "1.2.3.4"},
+		"untrusted proxy":   {"1.2.3.4:5000", []string{"5.6.7.8"}, "1.2.3.4"},
+		"trusted proxy":     {"10.0.0.1:5000", []string{"5.6.7.8"}, "5.6.7.8"},
+		"spoofed":           {"10.0.0.1:5000", []string{"6.6.6.6, 5.6.7.8"}, "5.6.7.8"},
+		"proxy chain":       {"10.0.0.1:5000", []string{"5.6.7.8, 10.0.0.2", "10.0.0.3"}, "5.6.7.8"},
+		"invalid forwarded": {"10.0.0.1:5000", []string{"unknown"}, "10.0.0.1"},
+		"without port":      {"1.2.3.4", nil, "1.2.3.4"},
+	}
+	for name, c := range cases {
+		headers := http.Header{"X-Forwarded-For": c.forwarded}
+		assert.Equal(t, c.expected, clientIP(c.remoteAddr, headers, trusted), name)
+	}
+}
+
+func TestClientFrom(t *testing.T) {
+	assert.Equal(t, "", ClientFrom(nil))
+	assert.Equal(t, "", ClientFrom(context.Background()))
+	assert.Equal(t, "web", ClientFrom(WithClient(context.Background(), "web")))
+}
+
+func TestTransHandlerInject(t *testing.T) {
+	h := TransHandler{
+		Injections: map[string][]ParamInjection{
+			"*": {{Param: "source", Source: InjectStatic, Value: "api", Override: true}},
+			"newad": {
+				{Param: "remote_addr", Source: InjectClientIP, Override: true},
+				{Param: "remote_browser", Source: InjectHeader, Header: "User-Agent"},
+				{Param: "token", Source: InjectHeader, Header: "X-Token", Override: true},
+				{Param: "client", Source: InjectClient},
+			},
+		},
+	}
+	in := &TransHandlerInput{
+		RemoteAddr: "1.2.3.4:5000",
+		Headers:    http.Header{"User-Agent": []string{"curl"}},
+		Context:    WithClient(context.Background(), "web"),
+	}
+	command := domain.TransCommand{
+		Command: "newad",
+		Params: []domain.TransParams{
+			{Key: "subject", Value: "sofa"},
+			{Key: "source", Value: "spoofed"},
+			{Key: "remote_browser", Value: "firefox"},
+			{Key: "token", Value: "forged"},
+		},
+	}
+	assert.Equal(t, []domain.TransParams{
+		{Key: "subject", Value: "sofa"},
+		{Key: "remote_browser", Value: "firefox"},
+		{Key: "source", Value: "api"},
+		{Key: "remote_addr", Value: "1.2.3.4"},
+		{Key: "client", Value: "web"},
+	}, h.inject(in, command))
+
+	// other commands only get the injections of every command
+	command = domain.TransCommand{Command: "transinfo"}
+	assert.Equal(t, []domain.TransParams{{Key: "source", Value: "api"}}, h.inject(in, command))
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// ResponsePolicy decides which keys of a trans response reach the caller,
+// and how their values are shown. Keys are matched with glob patterns, like
+// salt_*
+type ResponsePolicy struct {
+	// Allow the only keys returned, if any
+	Allow []string
+	// Deny the keys never returned
+	Deny []string
+	// Mask how the values of some keys are hidden. The first matching rule is used
+	Mask []MaskRule
+}
+
+// MaskRule hides the values of the keys matching Key, except for their last
+// KeepLast characters
+type MaskRule struct {
+	Key      string
+	KeepLast int
+}
+
+// policy returns the response policy of the command, or the one under "*"
+// if the command has none
+func (t *TransHandler) policy(command string) (ResponsePolicy, bool) {
+	policy, ok := t.ResponsePolicies[command]
+	if !ok {
+		policy, ok = t.ResponsePolicies["*"]
+	}
+	return policy, ok
+}
+
+// filterResponse applies the response policy of the command. Blobs can't be
+// partly shown, so the ones that would be masked are left out
+func (t *TransHandler) filterResponse(command string, val domain.TransResponse) domain.TransResponse {
+	policy, ok := t.policy(command)
+	if !ok {
+		return val
+	}
+	filtered := domain.TransResponse{Status: val.Status, Params: make(map[string]string)}
+	if len(val.Fields) == 0 {
+		for key, value := range val.Params {
+			if policy.returns(key) {
+				filtered.Params[key] = policy.mask(key, value)
+			}
+		}
+		return filtered
+	}
+	for _, field := range val.Fields {
+		if !policy.returns(field.Key) {
+			continue
+		}
+		if _, masked := policy.maskRule(field.Key); masked && field.Blob {
+			continue
+		}
+		field.Value = policy.mask(field.Key, field.Value)
+		filtered.AddField(field)
+	}
+	return filtered
+}
+
+// filterError applies the response policy of the command to the message of
+// err, which may carry the error text of trans, as if it were the error key of
+// the response. Errors without a message of their own are returned as they are
+func (t *TransHandler) filterError(command string, err error) error {
+	policy, ok := t.policy(command)
+	if !ok || err == nil {
+		return err
+	}
+	switch e := err.(type) {
+	case domain.BusyError, domain.ParamsError:
+		return err
+	case domain.TransError:
+		e.Message = policy.message(e.Message)
+		return e
+	}
+	if message := policy.message(err.Error()); message != "" {
+		return errors.New(message)
+	}
+	return errors.New("the command failed")
+}
+
+// returns checks if the key can reach the caller
+func (p ResponsePolicy) returns(key string) bool {
+	if len(p.Allow) > 0 && !matchesAny(p.Allow, key) {
+		return false
+	}
+	return !matchesAny(p.Deny, key)
+}
+
+// message returns an error message the way the error key would be returned:
+// empty if the key isn't returned, and masked if a rule says so
+func (p ResponsePolicy) message(message string) string {
+	if !p.returns("error") {
+		return ""
+	}
+	return p.mask("error", message)
+}
+
+// maskRule returns the first rule that masks the key, if any
+func (p ResponsePolicy) maskRule(key string) (MaskRule, bool) {
+	for _, rule := range p.Mask {
+		if matches(rule.Key, key) {
+			return rule, true
+		}
+	}
+	return MaskRule{}, false
+}
+
+// mask hides the value of the key, if any rule says so
+func (p ResponsePolicy) mask(key, value string) string {
+	rule, ok := p.maskRule(key)
+	if !ok {
+		return value
+	}
+	runes := []rune(value)
+	keep := rule.KeepLast
+	if keep > len(runes) {
+		keep = len(runes)
+	}
+	return strings.Repeat("*", len(runes)-keep) + string(runes[len(runes)-keep:])
+}
+
+// matchesAny checks if the key matches any of the patterns
+func matchesAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matches(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches checks if the key matches the glob pattern. Invalid patterns match nothing
+func matches(pattern, key string) bool {
+	ok, err := path.Match(pattern, key)
+	return err == nil && ok
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/Yapo/goutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/usecases"
+)
+
+func TestTransHandlerFilterResponse(t *testing.T) {
+	h := TransHandler{
+		ResponsePolicies: map[string]ResponsePolicy{
+			"get_account": {
+				Allow: []string{"account_id", "email", "phone*", "salt_*"},
+				Deny:  []string{"salt_*"},
+				Mask:  []MaskRule{{Key: "phone*", KeepLast: 4}, {Key: "email", KeepLast: 20}},
+			},
+			"*": {Deny: []string{"passwd"}},
+		},
+	}
+	val := domain.TransResponse{Status: usecases.TransOK}
+	val.Add("account_id", "42")
+	val.Add("email", "ana@yapo.cl")
+	val.Add("passwd", "$2a$hash")
+	val.Add("salt_1", "abc")
+	val.Add("phone", "+56912345678")
+	val.Add("phone_2", "123")
+
+	expected := domain.TransResponse{Status: usecases.TransOK}
+	expected.Add("account_id", "42")
+	expected.Add("email", "ana@yapo.cl")
+	expected.Add("phone", "********5678")
+	expected.Add("phone_2", "123")
+	assert.Equal(t, expected, h.filterResponse("get_account", val))
+
+	// commands without a policy of their own use the one under "*"
+	expected = domain.TransResponse{Status: usecases.TransOK}
+	expected.Add("account_id", "42")
+	expected.Add("email", "ana@yapo.cl")
+	expected.Add("salt_1", "abc")
+	expected.Add("phone", "+56912345678")
+	expected.Add("phone_2", "123")
+	assert.Equal(t, expected, h.filterResponse("loadad", val))
+
+	// responses with only Params are filtered too
+	params := domain.TransResponse{Status: usecases.TransOK, Params: map[string]string{"passwd": "x", "ok": "1"}}
+	assert.Equal(t, domain.TransResponse{
+		Status: usecases.TransOK,
+		Params: map[string]string{"ok": "1"},
+	}, h.filterResponse("loadad", params))
+
+	// without policies, responses are returned as they are
+	assert.Equal(t, val, (&TransHandler{}).filterResponse("get_account", val))
+}
+
+func TestTransHandlerFilterResponseBlobs(t *testing.T) {
+	h := TransHandler{
+		ResponsePolicies: map[string]ResponsePolicy{
+			"loadad": {Mask: []MaskRule{{Key: "image*", KeepLast: 2}}},
+		},
+	}
+	val := domain.TransResponse{Status: usecases.TransOK}
+	val.AddField(domain.TransField{Key: "image", Value: "\x89PNG\x00", Blob: true})
+	val.AddField(domain.TransField{Key: "image_name", Value: "photo.png"})
+	val.AddField(domain.TransField{Key: "body", Value: "\x00\x01", Blob: true})
+
+	// masking would corrupt the blob, so it's left out as a whole
+	expected := domain.TransResponse{Status: usecases.TransOK}
+	expected.AddField(domain.TransField{Key: "image_name", Value: "*******ng"})
+	expected.AddField(domain.TransField{Key: "body", Value: "\x00\x01", Blob: true})
+	assert.Equal(t, expected, h.filterResponse("loadad", val))
+}
+
+func TestTransHandlerFilterError(t *testing.T) {
+	h := TransHandler{
+		ResponsePolicies: map[string]ResponsePolicy{
+			"get_account": {Allow: []string{"account_id"}},
+			"*":           {Mask: []MaskRule{{Key: "error", KeepLast: 6}}},
+		},
+	}
+	transErr := domain.TransError{Class: domain.ErrorClassConflict, Code: "ERROR", Message: "passwd $2a$hash is wrong"}
+	assert.Equal(t,
+		domain.TransError{Class: domain.ErrorClassConflict, Code: "ERROR"},
+		h.filterError("get_account", transErr),
+	)
+	assert.Equal(t,
+		domain.TransError{Class: domain.ErrorClassConflict, Code: "ERROR", Message: "****************** wrong"},
+		h.filterError("loadad", transErr),
+	)
+	assert.EqualError(t, h.filterError("get_account", errors.New("passwd:$2a$hash")), "the command failed")
+	assert.EqualError(t, h.filterError("loadad", errors.New("passwd:$2a$hash")), "*********a$hash")
+	assert.Equal(t, domain.BusyError{}, h.filterError("get_account", domain.BusyError{}))
+	assert.Nil(t, h.filterError("get_account", nil))
+
+	// without policies, errors are returned as they are
+	assert.Equal(t, transErr, (&TransHandler{}).filterError("get_account", transErr))
+}
+
+func TestTransHandlerExecuteFiltered(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account", Context: context.Background()}
+	command := domain.TransCommand{Command: "get_account", Params: []domain.TransParams{}}
+	val := domain.TransResponse{Status: usecases.TransOK}
+	val.Add("account_id", "42")
+	val.Add("passwd", "$2a$hash")
+	m.On("ExecuteCommand", mock.Anything, command).Return(val, nil).Once()
+	h := TransHandler{
+		Interactor:       &m,
+		ResponsePolicies: map[string]ResponsePolicy{"get_account": {Deny: []string{"passwd"}}},
+	}
+
+	r := h.Execute(MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransRequestOutput{
+			Status:   usecases.TransOK,
+			Response: map[string]string{"account_id": "42"},
+		},
+	}, r)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteFilteredError(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account", Context: context.Background()}
+	command := domain.TransCommand{Command: "get_account", Params: []domain.TransParams{}}
+	val := domain.TransResponse{Status: usecases.TransOK}
+	val.Add("account_id", "42")
+	val.Add("error", "ACCOUNT_LOCKED")
+	m.On("ExecuteCommand", mock.Anything, command).Return(val, nil).Once()
+	h := TransHandler{
+		Interactor:       &m,
+		ResponsePolicies: map[string]ResponsePolicy{"get_account": {Allow: []string{"account_id"}}},
+	}
+
+	// the error is left out of the response, but still decides the status
+	r := h.Execute(MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, http.StatusBadRequest, r.Code)
+	assert.Equal(t, map[string]string{"account_id": "42"}, r.Body.(TransRequestOutput).Response)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteFilteredInternalError(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account", Context: context.Background()}
+	command := domain.TransCommand{Command: "get_account", Params: []domain.TransParams{}}
+	m.On("ExecuteCommand", mock.Anything, command).
+		Return(domain.TransResponse{}, errors.New(`trans: invalid key-value format: "passwd"`)).Once()
+	h := TransHandler{
+		Interactor:       &m,
+		ResponsePolicies: map[string]ResponsePolicy{"get_account": {Allow: []string{"account_id"}}},
+	}
+
+	// the text of trans in the error never reaches the caller
+	r := h.Execute(MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, &goutils.Response{
+		Code: http.StatusInternalServerError,
+		Body: &goutils.GenericError{ErrorMessage: "the command failed"},
+	}, r)
+	m.AssertExpectations(t)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/Yapo/goutils"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+	mux "gopkg.in/gorilla/mux.v1"
+)
+
+// ParamLocation is the part of the request a route param is taken from
+type ParamLocation string
+
+const (
+	// ParamFromPath a var of the route path
+	ParamFromPath ParamLocation = "path"
+	// ParamFromQuery a param of the query string
+	ParamFromQuery ParamLocation = "query"
+	// ParamFromBody a field of the JSON body
+	ParamFromBody ParamLocation = "body"
+)
+
+// RouteParam is where the value of a trans param is taken from
+type RouteParam struct {
+	From ParamLocation
+	Name string
+}
+
+// RequestRewriter is implemented by handlers whose requests must be rewritten
+// before any other wrapper sees them
+type RequestRewriter interface {
+	Rewrite(handler http.HandlerFunc) http.HandlerFunc
+}
+
+// RouteHandler serves a REST-style route mapped onto a trans command. Its
+// requests are rewritten into the params of the command, that is executed by
+// Trans, and the keys of the response can be renamed
+type RouteHandler struct {
+	Trans   *TransHandler
+	Command string
+	// Params where the value of each trans param is taken from
+	Params map[string]RouteParam
+	// Response renames the keys of the response. If set, keys not listed are left out
+	Response map[string]string
+}
+
+// RoutedRequest is what a REST-style route received, before it was rewritten
+type RoutedRequest struct {
+	Command string
+	Body    []byte
+}
+
+// routedKey is the context key of the routed request
+type routedKey struct{}
+
+// RoutedRequestFrom returns the routed request carried by ctx, if any
+func RoutedRequestFrom(ctx context.Context) (RoutedRequest, bool) {
+	routed, ok := ctx.Value(routedKey{}).(RoutedRequest)
+	return routed, ok
+}
+
+// Input returns a fresh, empty instance of TransHandlerInput
+func (h *RouteHandler) Input() HandlerInput {
+	return &TransHandlerInput{}
+}
+
+// Execute executes the command of the route with the params of the rewritten request
+func (h *RouteHandler) Execute(ig InputGetter) *goutils.Response {
+	input, response := ig()
+	if response != nil {
+		return response
+	}
+	in := input.(*TransHandlerInput)
+	in.Command = h.Command
+	return h.Trans.execute(in, h.reshape)
+}
+
+// Rewrite turns the request into one for the generic endpoint, whose body
+// holds the params of the command. What the route received is carried by the
+// context of the request
+func (h *RouteHandler) Rewrite(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		original, err := ioutil.ReadAll(r.Body)
+		var body map[string]interface{}
+		if err == nil {
+			err = json.NewDecoder(bytes.NewReader(original)).Decode(&body)
+		}
+		if err != nil && err != io.EOF {
+			response := &goutils.Response{
+				Code: http.StatusBadRequest,
+				Body: goutils.GenericError{ErrorMessage: err.Error()},
+			}
+			goutils.CreateJSON(response)
+			goutils.WriteJSONResponse(w, response)
+			return
+		}
+		rewritten, _ := json.Marshal(map[string]interface{}{"params": h.params(r, body)}) // nolint: gosec
+		r.Body = ioutil.NopCloser(bytes.NewReader(rewritten))
+		r.ContentLength = int64(len(rewritten))
+		routed := RoutedRequest{Command: h.Command, Body: original}
+		handler(w, r.WithContext(context.WithValue(r.Context(), routedKey{}, routed)))
+	}
+}
+
+// params takes the params of the command from the request. Repeated query
+// params are sent as lists
+func (h *RouteHandler) params(r *http.Request, body map[string]interface{}) map[string]interface{} {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	params := make(map[string]interface{})
+	for param, source := range h.Params {
+		switch source.From {
+		case ParamFromPath:
+			if value, ok := vars[source.Name]; ok {
+				params[param] = value
+			}
+		case ParamFromQuery:
+			values := query[source.Name]
+			if len(values) == 1 {
+				params[param] = values[0]
+			} else if len(values) > 1 {
+				list := make([]interface{}, 0, len(values))
+				for _, value := range values {
+					list = append(list, value)
+				}
+				params[param] = list
+			}
+		case ParamFromBody:
+			if value, ok := body[source.Name]; ok {
+				params[param] = value
+			}
+		}
+	}
+	return params
+}
+
+// reshape renames the keys of the response, leaving out the ones not listed
+func (h *RouteHandler) reshape(val domain.TransResponse) domain.TransResponse {
+	if len(h.Response) == 0 {
+		return val
+	}
+	reshaped := domain.TransResponse{Status: val.Status, Params: make(map[string]string)}
+	if len(val.Fields) == 0 {
+		for key, value := range val.Params {
+			if name, ok := h.Response[key]; ok {
+				reshaped.Params[name] = value
+			}
+		}
+		return reshaped
+	}
+	for _, field := range val.Fields {
+		if name, ok := h.Response[field.Key]; ok {
+			field.Key = name
+			reshaped.AddField(field)
+		}
+	}
+	return reshaped
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Yapo/goutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/usecases"
+	mux "gopkg.in/gorilla/mux.v1"
+)
+
+func TestRouteHandlerRewrite(t *testing.T) {
+	h := RouteHandler{
+		Command: "bump_ad",
+		Params: map[string]RouteParam{
+			"ad_id":    {From: ParamFromPath, Name: "ad_id"},
+			"category": {From: ParamFromQuery, Name: "cat"},
+			"region":   {From: ParamFromQuery, Name: "region"},
+			"days":     {From: ParamFromBody, Name: "days"},
+			"missing":  {From: ParamFromBody, Name: "nothing"},
+		},
+	}
+	var body string
+	var routed RoutedRequest
+	router := mux.NewRouter()
+	router.HandleFunc("/ads/{ad_id}/bump", h.Rewrite(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		routed, _ = RoutedRequestFrom(r.Context())
+	}))
+
+	original := `{"days": 7}`
+	r := httptest.NewRequest("POST", "/ads/42/bump?cat=1020&region=13&region=15", strings.NewReader(original))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"params": {"ad_id": "42", "category": "1020", "region": ["13", "15"], "days": 7}}`, body)
+	assert.Equal(t, RoutedRequest{Command: "bump_ad", Body: []byte(original)}, routed)
+
+	// requests without body are fine
+	r = httptest.NewRequest("POST", "/ads/42/bump", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"params": {"ad_id": "42"}}`, body)
+
+	r = httptest.NewRequest("POST", "/ads/42/bump", strings.NewReader("not json"))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestRouteHandlerReshape(t *testing.T) {
+	h := RouteHandler{Response: map[string]string{"account_id": "id", "email": "email"}}
+	val := domain.TransResponse{Status: usecases.TransOK}
+	val.Add("account_id", "42")
+	val.Add("email", fakeEmail)
+	val.Add("passwd", "$2a$hash")
+
+	reshaped := domain.TransResponse{Status: usecases.TransOK}
+	reshaped.Add("id", "42")
+	reshaped.Add("email", fakeEmail)
+	assert.Equal(t, reshaped, h.reshape(val))
+
+	// without renames, responses are returned as they are
+	assert.Equal(t, val, (&RouteHandler{}).reshape(val))
+}
+
+func TestRouteHandlerExecute(t *testing.T) {
+	m := MockTransInteractor{}
+	command := domain.TransCommand{
+		Command: "get_account",
+		Params:  []domain.TransParams{{Key: "email", Value: fakeEmail}},
+	}
+	val := domain.TransResponse{Status: usecases.TransOK}
+	val.Add("account_id", "42")
+	val.Add("passwd", "$2a$hash")
+	m.On("ExecuteCommand", mock.Anything, command).Return(val, nil).Once()
+	h := RouteHandler{
+		Trans: &TransHandler{
+			Interactor:       &m,
+			ResponsePolicies: map[string]ResponsePolicy{"get_account": {Deny: []string{"passwd"}}},
+		},
+		Command:  "get_account",
+		Response: map[string]string{"account_id": "id", "passwd": "password"},
+	}
+	var expected *TransHandlerInput
+	assert.IsType(t, expected, h.Input())
+
+	// the command is the one of the route, and policies apply before renaming
+	input := TransHandlerInput{Command: "transinfo", Params: map[string]interface{}{"email": fakeEmail}}
+	r := h.Execute(MakeMockInputTransGetter(&input, nil))
+	assert.Equal(t, &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransRequestOutput{
+			Status:   usecases.TransOK,
+			Response: map[string]string{"id": "42"},
+		},
+	}, r)
+	m.AssertExpectations(t)
+}
@@ -1,7 +1,14 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 
 	"github.com/Yapo/goutils"
 	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
@@ -13,18 +20,126 @@ import (
 // { status: string, response: json }
 type TransHandler struct {
 	Interactor usecases.ExecuteTransUsecase
+	// Injections the params set on the server side for each command. The
+	// ones under "*" apply to every command
+	Injections map[string][]ParamInjection
+	// TrustedProxies the proxies whose X-Forwarded-For is honoured to find the client IP
+	TrustedProxies []*net.IPNet
+	// ResponsePolicies the keys of the response returned for each command, and
+	// how they are masked. The one under "*" applies to commands without their own
+	ResponsePolicies map[string]ResponsePolicy
 }
 
-// TransHandlerInput struct that represents the input
+// TransHandlerInput struct that represents the input. The version and the
+// command are only taken from the route, so the body can't change the
+// command the caller was authorized for
 type TransHandlerInput struct {
-	Command string                 `get:"command"`
+	Version string                 `get:"version" json:"-"`
+	Command string                 `get:"command" json:"-"`
 	Params  map[string]interface{} `json:"params"`
+	// DryRun and DryRunHeader request the command to be validated without
+	// committing it, either by the dry_run query param or the X-Dry-Run header
+	DryRun       string `query:"dry_run" json:"-"`
+	DryRunHeader string `header:"X-Dry-Run" json:"-"`
+	// Priority the class of traffic requested by the caller: interactive or batch
+	Priority string `header:"X-Priority" json:"-"`
+	// Context the context of the request, so the command is abandoned if the caller goes away
+	Context context.Context `request:"context" json:"-"`
+	// RemoteAddr and Headers are where injected params are taken from
+	RemoteAddr string      `request:"remote_addr" json:"-"`
+	Headers    http.Header `request:"headers" json:"-"`
 }
 
 // TransRequestOutput struct that represents the output
 type TransRequestOutput struct {
 	Status   string            `json:"status"`
 	Response map[string]string `json:"response"`
+	// Blobs the keys of response whose values are base64 encoded blobs
+	Blobs []string `json:"blobs,omitempty"`
+	// Errors the params rejected before reaching trans or by trans, if any
+	Errors []ParamErrorOutput `json:"errors,omitempty"`
+	// Error describes why the command failed, if it did
+	Error *ErrorOutput `json:"error,omitempty"`
+}
+
+// ParamErrorOutput struct that represents a rejected param
+type ParamErrorOutput struct {
+	Param  string `json:"param"`
+	Reason string `json:"reason"`
+}
+
+// ErrorOutput struct that represents a failed command
+type ErrorOutput struct {
+	Class   domain.ErrorClass `json:"class"`
+	Code    string            `json:"code"`
+	Message string            `json:"message,omitempty"`
+}
+
+// errorStatus the http status returned for each class of error
+var errorStatus = map[domain.ErrorClass]int{ // nolint: gochecknoglobals
+	domain.ErrorClassBadRequest:  http.StatusBadRequest,
+	domain.ErrorClassNotFound:    http.StatusNotFound,
+	domain.ErrorClassConflict:    http.StatusConflict,
+	domain.ErrorClassValidation:  http.StatusUnprocessableEntity,
+	domain.ErrorClassUpstream:    http.StatusBadGateway,
+	domain.ErrorClassTimeout:     http.StatusGatewayTimeout,
+	domain.ErrorClassUnavailable: http.StatusServiceUnavailable,
+	domain.ErrorClassOverloaded:  http.StatusTooManyRequests,
+}
+
+// TransRequestFieldsOutput struct that represents the output from version 2
+// onwards, where the response keeps the order and the repeated keys returned by trans
+type TransRequestFieldsOutput struct {
+	Status   string         `json:"status"`
+	Response ResponseFields `json:"response"`
+	// Blobs the keys of response whose values are base64 encoded blobs
+	Blobs []string `json:"blobs,omitempty"`
+	// Errors the params rejected before reaching trans or by trans, if any
+	Errors []ParamErrorOutput `json:"errors,omitempty"`
+	// Error describes why the command failed, if it did
+	Error *ErrorOutput `json:"error,omitempty"`
+}
+
+// ResponseFields are the fields of a trans response. They are marshaled as a
+// JSON object that keeps the order in which trans returned them. Repeated keys
+// are grouped in an array, placed where the key first appeared.
+type ResponseFields []domain.TransField
+
+// MarshalJSON writes the fields as an ordered JSON object
+func (f ResponseFields) MarshalJSON() ([]byte, error) {
+	keys := make([]string, 0, len(f))
+	values := make(map[string][]string)
+	for _, field := range f {
+		if _, ok := values[field.Key]; !ok {
+			keys = append(keys, field.Key)
+		}
+		values[field.Key] = append(values[field.Key], field.Value)
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encoder.Encode(key); err != nil {
+			return nil, err
+		}
+		buf.WriteByte(':')
+		var err error
+		if len(values[key]) == 1 {
+			err = encoder.Encode(values[key][0])
+		} else {
+			err = encoder.Encode(values[key])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	// the encoder terminates each value with a newline; those are not needed
+	return bytes.Replace(buf.Bytes(), []byte("\n"), nil, -1), nil
 }
 
 // Input returns a fresh, empty instance of transHandlerInput
@@ -41,20 +156,54 @@ func (t *TransHandler) Execute(ig InputGetter) *goutils.Response {
 	if response != nil {
 		return response
 	}
-	in := input.(*TransHandlerInput)
+	return t.execute(input.(*TransHandlerInput), nil)
+}
+
+// execute executes the command of the input. reshape, if given, changes the
+// response after the response policies are applied
+func (t *TransHandler) execute(
+	in *TransHandlerInput,
+	reshape func(domain.TransResponse) domain.TransResponse,
+) *goutils.Response {
+	var response *goutils.Response
 	command := parseInput(in)
-	var val domain.TransResponse
-	val, err := t.Interactor.ExecuteCommand(command)
-	// handle trans errors, database errors, or general reported errors by trans
+	command.Params = t.inject(in, command)
+	command.Priority = t.priority(in)
+	ctx := in.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	val, err := t.Interactor.ExecuteCommand(ctx, command)
+	// the status is decided on the whole response, but only what the
+	// response policies allow is returned
+	output := t.filterResponse(command.Command, val)
+	err = t.filterError(command.Command, err)
+	if reshape != nil {
+		output = reshape(output)
+	}
+	// trans is overloaded: tell the caller when to try again
+	if busyErr, ok := err.(domain.BusyError); ok {
+		return busyResponse(in, output, busyErr)
+	}
+	// trans errors are answered according to their class
+	if transErr, ok := err.(domain.TransError); ok {
+		code, ok := errorStatus[transErr.Class]
+		if !ok {
+			code = http.StatusBadRequest
+		}
+		response = &goutils.Response{
+			Code: code,
+			Body: makeOutput(in, output, err),
+		}
+		return response
+	}
+	// handle rejected params, or errors reported without a class
 	if _, ok := val.Params["error"]; ok ||
 		val.Status == usecases.TransError ||
 		val.Status == usecases.TransDatabaseError {
 		response = &goutils.Response{
 			Code: http.StatusBadRequest,
-			Body: TransRequestOutput{
-				Status:   val.Status,
-				Response: val.Params,
-			},
+			Body: makeOutput(in, output, err),
 		}
 		return response
 	}
@@ -72,17 +221,131 @@ func (t *TransHandler) Execute(ig InputGetter) *goutils.Response {
 
 	response = &goutils.Response{
 		Code: http.StatusOK,
-		Body: TransRequestOutput{
+		Body: makeOutput(in, output, nil),
+	}
+	return response
+}
+
+// busyResponse tells the caller that trans is overloaded, and when to try again
+func busyResponse(in *TransHandlerInput, val domain.TransResponse, err domain.BusyError) *goutils.Response {
+	retryAfter := int(math.Ceil(err.RetryAfter.Seconds()))
+	return &goutils.Response{
+		Code: http.StatusServiceUnavailable,
+		Body: HeaderedBody{
+			Body: makeOutput(in, val, err),
+			Headers: http.Header{
+				"Retry-After": []string{strconv.Itoa(retryAfter)},
+			},
+		},
+	}
+}
+
+// makeOutput presents the trans response in the format of the requested api
+// version. Version 1 returns a flat object; later versions keep the order and
+// the repeated keys of the response. Params rejected by err are listed too
+func makeOutput(input *TransHandlerInput, val domain.TransResponse, err error) interface{} {
+	val, blobs := encodeBlobs(val)
+	errorOutput, paramErrors := makeErrorOutput(err)
+	if input.Version == "" || input.Version == "1" {
+		return TransRequestOutput{
 			Status:   val.Status,
 			Response: val.Params,
-		},
+			Blobs:    blobs,
+			Errors:   paramErrors,
+			Error:    errorOutput,
+		}
 	}
-	return response
+	return TransRequestFieldsOutput{
+		Status:   val.Status,
+		Response: ResponseFields(val.Fields),
+		Blobs:    blobs,
+		Errors:   paramErrors,
+		Error:    errorOutput,
+	}
+}
+
+// makeErrorOutput describes err, along with the params that caused it. The
+// reason of a param rejected by trans is the error code trans returned for it
+func makeErrorOutput(err error) (*ErrorOutput, []ParamErrorOutput) {
+	var paramErrors []ParamErrorOutput
+	switch e := err.(type) {
+	case domain.ParamsError:
+		for _, paramErr := range e {
+			paramErrors = append(paramErrors, ParamErrorOutput{
+				Param:  paramErr.Param,
+				Reason: paramErr.Reason,
+			})
+		}
+		return nil, paramErrors
+	case domain.TransError:
+		for _, fieldErr := range e.Fields {
+			paramErrors = append(paramErrors, ParamErrorOutput{
+				Param:  fieldErr.Param,
+				Reason: fieldErr.Code,
+			})
+		}
+		return &ErrorOutput{Class: e.Class, Code: e.Code, Message: e.Message}, paramErrors
+	}
+	return nil, nil
+}
+
+// encodeBlobs returns a copy of the response where every blob value is
+// base64 encoded, so binary data survives the JSON output, along with the
+// keys of those blobs
+func encodeBlobs(val domain.TransResponse) (domain.TransResponse, []string) {
+	var blobs []string
+	seen := make(map[string]bool)
+	for _, field := range val.Fields {
+		if field.Blob && !seen[field.Key] {
+			blobs = append(blobs, field.Key)
+			seen[field.Key] = true
+		}
+	}
+	if len(blobs) == 0 {
+		return val, nil
+	}
+	encoded := domain.TransResponse{Status: val.Status}
+	for _, field := range val.Fields {
+		if field.Blob {
+			field.Value = base64.StdEncoding.EncodeToString([]byte(field.Value))
+		}
+		encoded.AddField(field)
+	}
+	return encoded, blobs
+}
+
+// priorityKey is the context key of the priority of the authenticated client
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx that carries the priority of the authenticated client
+func WithPriority(ctx context.Context, priority domain.Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFrom returns the priority of the authenticated client carried by ctx, if any
+func PriorityFrom(ctx context.Context) domain.Priority {
+	if ctx == nil {
+		return ""
+	}
+	priority, _ := ctx.Value(priorityKey{}).(domain.Priority)
+	return priority
+}
+
+// priority returns the priority of the caller, given by its client or
+// requested on the X-Priority header. Unknown priorities are left empty, so
+// those callers are interactive
+func (t *TransHandler) priority(input *TransHandlerInput) domain.Priority {
+	if priority := PriorityFrom(input.Context); priority != "" {
+		return priority
+	}
+	priority, _ := domain.ParsePriority(input.Priority)
+	return priority
 }
 
 func parseInput(input *TransHandlerInput) domain.TransCommand {
 	command := domain.TransCommand{
 		Command: input.Command,
+		DryRun:  isDryRun(input.DryRun) || isDryRun(input.DryRunHeader),
 	}
 
 	params := make([]domain.TransParams, 0)
@@ -99,7 +362,7 @@ func parseInput(input *TransHandlerInput) domain.TransCommand {
 						}
 						params = append(params, param)
 					}
-				} else if _, ok := val.(string); ok {
+				} else {
 					param := domain.TransParams{
 						Key:   key,
 						Value: val,
@@ -118,3 +381,9 @@ func parseInput(input *TransHandlerInput) domain.TransCommand {
 	command.Params = params
 	return command
 }
+
+// isDryRun parses a dry run flag. Any value that is not a valid boolean is false
+func isDryRun(value string) bool {
+	dryRun, err := strconv.ParseBool(value)
+	return err == nil && dryRun
+}
@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/Yapo/goutils"
 	"github.com/stretchr/testify/assert"
@@ -20,8 +23,11 @@ type MockTransInteractor struct {
 	mock.Mock
 }
 
-func (m *MockTransInteractor) ExecuteCommand(command domain.TransCommand) (domain.TransResponse, error) {
-	ret := m.Called(command)
+func (m *MockTransInteractor) ExecuteCommand(
+	ctx context.Context,
+	command domain.TransCommand,
+) (domain.TransResponse, error) {
+	ret := m.Called(ctx, command)
 	return ret.Get(0).(domain.TransResponse), ret.Error(1)
 }
 
@@ -50,7 +56,7 @@ func TestTransHandlerExecuteOK(t *testing.T) {
 	response := domain.TransResponse{
 		Status: usecases.TransOK,
 	}
-	m.On("ExecuteCommand", command).Return(response, nil).Once()
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, nil).Once()
 	h := TransHandler{Interactor: &m}
 
 	expectedResponse := &goutils.Response{
@@ -91,7 +97,7 @@ func TestTransHandlerParseInput(t *testing.T) {
 	response.Params["account_id"] = "1"
 	response.Params["email"] = fakeEmail
 	response.Params["is_company"] = "true"
-	m.On("ExecuteCommand", command).Return(response, nil).Once()
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, nil).Once()
 	h := TransHandler{Interactor: &m}
 
 	requestOutput := TransRequestOutput{
@@ -123,7 +129,7 @@ func TestTransHandlerExecuteError(t *testing.T) {
 	response := domain.TransResponse{
 		Status: usecases.TransError,
 	}
-	m.On("ExecuteCommand", command).Return(response, nil).Once()
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, nil).Once()
 	h := TransHandler{Interactor: &m}
 
 	expectedResponse := &goutils.Response{
@@ -148,7 +154,7 @@ func TestTransHandlerExecuteInternalError(t *testing.T) {
 		Params:  make([]domain.TransParams, 0),
 	}
 	response := domain.TransResponse{}
-	m.On("ExecuteCommand", command).Return(response, errors.New("Error")).Once()
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, errors.New("Error")).Once()
 	h := TransHandler{Interactor: &m}
 
 	expectedResponse := &goutils.Response{
@@ -180,3 +186,238 @@ func TestTransHandlerInputError(t *testing.T) {
 
 	m.AssertExpectations(t)
 }
+
+func TestTransHandlerExecuteFieldsOutput(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Version: "2", Command: "get_packs_by_account"}
+	command := domain.TransCommand{
+		Command: "get_packs_by_account",
+		Params:  make([]domain.TransParams, 0),
+	}
+	response := domain.TransResponse{
+		Status: usecases.TransOK,
+		Fields: []domain.TransField{
+			{Key: "pack_id", Value: "1"},
+			{Key: "type", Value: "car"},
+			{Key: "pack_id", Value: "2"},
+			{Key: "account_id", Value: "7"},
+		},
+	}
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, nil).Once()
+	h := TransHandler{Interactor: &m}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransRequestFieldsOutput{
+			Status:   usecases.TransOK,
+			Response: ResponseFields(response.Fields),
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(getter)
+	assert.Equal(t, expectedResponse, r)
+
+	goutils.CreateJSON(r)
+	assert.Equal(t,
+		`{"status":"TRANS_OK","response":{"pack_id":["1","2"],"type":"car","account_id":"7"}}`+"\n",
+		r.Body.(fmt.Stringer).String(),
+	)
+	m.AssertExpectations(t)
+}
+
+func TestResponseFieldsMarshalEmpty(t *testing.T) {
+	b, err := ResponseFields(nil).MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(b))
+}
+
+func TestTransHandlerExecuteBlobOutput(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "imgget"}
+	command := domain.TransCommand{
+		Command: "imgget",
+		Params:  make([]domain.TransParams, 0),
+	}
+	response := domain.TransResponse{Status: usecases.TransOK}
+	response.Add("name", "photo.jpg")
+	response.AddField(domain.TransField{Key: "image", Value: "\xff\xd8\n\x00", Blob: true})
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, nil).Once()
+	h := TransHandler{Interactor: &m}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusOK,
+		Body: TransRequestOutput{
+			Status: usecases.TransOK,
+			Response: map[string]string{
+				"name":  "photo.jpg",
+				"image": "/9gKAA==",
+			},
+			Blobs: []string{"image"},
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(getter)
+	assert.Equal(t, expectedResponse, r)
+	// the response given by the interactor must not be modified
+	assert.Equal(t, "\xff\xd8\n\x00", response.Params["image"])
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteParamsError(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{
+		Command: "newad",
+		Params: map[string]interface{}{
+			"tags": []interface{}{[]interface{}{"a"}},
+		},
+	}
+	command := domain.TransCommand{
+		Command: "newad",
+		Params: []domain.TransParams{
+			{Key: "tags", Value: []interface{}{"a"}},
+		},
+	}
+	err := domain.ParamsError{{Param: "tags", Reason: "unsupported value type []interface {}"}}
+	response := domain.TransResponse{Status: usecases.TransError}
+	response.Add("error", err.Error())
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, err).Once()
+	h := TransHandler{Interactor: &m}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusBadRequest,
+		Body: TransRequestOutput{
+			Status:   usecases.TransError,
+			Response: response.Params,
+			Errors: []ParamErrorOutput{
+				{Param: "tags", Reason: "unsupported value type []interface {}"},
+			},
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(getter)
+	assert.Equal(t, expectedResponse, r)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerExecuteBusy(t *testing.T) {
+	m := MockTransInteractor{}
+	input := TransHandlerInput{Command: "get_account"}
+	command := domain.TransCommand{
+		Command: "get_account",
+		Params:  make([]domain.TransParams, 0),
+	}
+	err := domain.BusyError{RetryAfter: 1500 * time.Millisecond}
+	response := domain.TransResponse{}
+	response.Add("error", err.Error())
+	m.On("ExecuteCommand", mock.Anything, command).Return(response, err).Once()
+	h := TransHandler{Interactor: &m}
+
+	expectedResponse := &goutils.Response{
+		Code: http.StatusServiceUnavailable,
+		Body: HeaderedBody{
+			Body: TransRequestOutput{
+				Response: response.Params,
+			},
+			Headers: http.Header{"Retry-After": []string{"2"}},
+		},
+	}
+
+	getter := MakeMockInputTransGetter(&input, nil)
+	r := h.Execute(getter)
+	assert.Equal(t, expectedResponse, r)
+	m.AssertExpectations(t)
+}
+
+func TestTransHandlerParseInputDryRun(t *testing.T) {
+	cases := map[string]TransHandlerInput{
+		"query":  {Command: "newad", DryRun: "1"},
+		"header": {Command: "newad", DryRunHeader: "true"},
+	}
+	for name, input := range cases {
+		t.Run(name, func(t *testing.T) {
+			command := parseInput(&input)
+			assert.True(t, command.DryRun)
+		})
+	}
+	command := parseInput(&TransHandlerInput{Command: "newad", DryRun: "yes"})
+	assert.False(t, command.DryRun)
+}
+
+func TestTransHandlerPriority(t *testing.T) {
+	h := TransHandler{}
+	batch := WithPriority(context.Background(), domain.PriorityBatch)
+	cases := map[string]struct {
+		input    TransHandlerInput
+		expected domain.Priority
+	}{
+		"default":        {TransHandlerInput{}, ""},
+		"header":         {TransHandlerInput{Priority: "batch"}, domain.PriorityBatch},
+		"unknown header": {TransHandlerInput{Priority: "urgent"}, ""},
+		"client":         {TransHandlerInput{Context: batch}, domain.PriorityBatch},
+		"client over header": {
+			TransHandlerInput{Context: batch, Priority: "interactive"},
+			domain.PriorityBatch,
+		},
+		"client without priority": {
+			TransHandlerInput{Context: WithClient(context.Background(), "web"), Priority: "batch"},
+			domain.PriorityBatch,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.expected, h.priority(&c.input))
+		})
+	}
+}
+
+func TestTransHandlerExecuteTransErrors(t *testing.T) {
+	cases := map[domain.ErrorClass]int{
+		domain.ErrorClassBadRequest:  http.StatusBadRequest,
+		domain.ErrorClassNotFound:    http.StatusNotFound,
+		domain.ErrorClassConflict:    http.StatusConflict,
+		domain.ErrorClassValidation:  http.StatusUnprocessableEntity,
+		domain.ErrorClassUpstream:    http.StatusBadGateway,
+		domain.ErrorClassTimeout:     http.StatusGatewayTimeout,
+		domain.ErrorClassUnavailable: http.StatusServiceUnavailable,
+		domain.ErrorClassOverloaded:  http.StatusTooManyRequests,
+	}
+	for class, code := range cases {
+		t.Run(string(class), func(t *testing.T) {
+			m := MockTransInteractor{}
+			input := TransHandlerInput{Command: "create_account"}
+			command := domain.TransCommand{
+				Command: "create_account",
+				Params:  make([]domain.TransParams, 0),
+			}
+			err := domain.TransError{
+				Class: class,
+				Code:  usecases.TransError,
+				Fields: []domain.FieldError{
+					{Param: "email", Code: "ERROR_EMAIL_INVALID"},
+				},
+			}
+			response := domain.TransResponse{Status: usecases.TransError}
+			m.On("ExecuteCommand", mock.Anything, command).Return(response, err).Once()
+			h := TransHandler{Interactor: &m}
+
+			expectedResponse := &goutils.Response{
+				Code: code,
+				Body: TransRequestOutput{
+					Status: usecases.TransError,
+					Errors: []ParamErrorOutput{
+						{Param: "email", Reason: "ERROR_EMAIL_INVALID"},
+					},
+					Error: &ErrorOutput{Class: class, Code: usecases.TransError},
+				},
+			}
+
+			getter := MakeMockInputTransGetter(&input, nil)
+			r := h.Execute(getter)
+			assert.Equal(t, expectedResponse, r)
+			m.AssertExpectations(t)
+		})
+	}
+}
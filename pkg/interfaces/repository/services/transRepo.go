@@ -1,15 +1,14 @@
 package services
 
 import (
-	"reflect"
-	"strconv"
+	"context"
 
 	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
 )
 
 // TransHandler is an interface to use Trans functions
 type TransHandler interface {
-	SendCommand(string, []domain.TransParams) (map[string]string, error)
+	SendCommand(context.Context, domain.TransCommand) ([]domain.TransField, error)
 }
 
 // TransFactory is an interface that abstracts the Factory Pattern for creating TransHandler objects
@@ -30,31 +29,26 @@ func NewTransRepo(transFactory TransFactory) *TransRepo {
 }
 
 // Execute executes the specified trans command
-func (repo *TransRepo) Execute(command domain.TransCommand) (domain.TransResponse, error) {
+func (repo *TransRepo) Execute(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
 	response := domain.TransResponse{
 		Params: make(map[string]string),
 	}
-	resp, err := repo.transaction(command.Command, command.Params)
+	resp, err := repo.transaction(ctx, command)
 	if err != nil {
-		response.Params["error"] = err.Error()
+		response.Add("error", err.Error())
 		return response, err
 	}
-	if status, ok := resp["status"]; ok {
-		response.Status = status
-		delete(resp, "status")
-	}
-	for key, val := range resp {
-		response.Params[key] = val
+	for _, field := range resp {
+		if field.Key == "status" {
+			response.Status = field.Value
+			continue
+		}
+		response.AddField(field)
 	}
 	return response, nil
 }
 
-func (repo *TransRepo) transaction(method string, transParams []domain.TransParams) (map[string]string, error) {
+func (repo *TransRepo) transaction(ctx context.Context, command domain.TransCommand) ([]domain.TransField, error) {
 	trans := repo.transFactory.MakeTransHandler()
-	for _, transParam := range transParams {
-		if reflect.TypeOf(transParam.Value).Kind() == reflect.Int {
-			transParam.Value = strconv.Itoa(transParam.Value.(int))
-		}
-	}
-	return trans.SendCommand(method, transParams)
+	return trans.SendCommand(ctx, command)
 }
@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -19,9 +20,9 @@ type MockTransHandler struct {
 	mock.Mock
 }
 
-func (m *MockTransHandler) SendCommand(command string, params []domain.TransParams) (map[string]string, error) {
-	ret := m.Called(command, params)
-	return ret.Get(0).(map[string]string), ret.Error(1)
+func (m *MockTransHandler) SendCommand(ctx context.Context, command domain.TransCommand) ([]domain.TransField, error) {
+	ret := m.Called(ctx, command)
+	return ret.Get(0).([]domain.TransField), ret.Error(1)
 }
 
 type MockTransFactory struct {
@@ -46,9 +47,8 @@ func TestNewTransRepo(t *testing.T) {
 
 func TestExecuteError(t *testing.T) {
 	cmd := command1
-	params := []domain.TransParams{}
 	expectedErr := errors.New("trans error")
-	responseParams := make(map[string]string)
+	responseParams := []domain.TransField{}
 
 	command := domain.TransCommand{
 		Command: cmd,
@@ -56,18 +56,18 @@ func TestExecuteError(t *testing.T) {
 	}
 
 	handler := MockTransHandler{}
-	handler.On("SendCommand", cmd, params).Return(responseParams, expectedErr).Once()
+	handler.On("SendCommand", mock.Anything, command).Return(responseParams, expectedErr).Once()
 
 	factory := MockTransFactory{}
 	factory.On("MakeTransHandler").Return(&handler)
 
 	repo := NewTransRepo(&factory)
 
-	response, err := repo.Execute(command)
+	response, err := repo.Execute(context.Background(), command)
 	expectedResponse := domain.TransResponse{
 		Params: make(map[string]string),
 	}
-	expectedResponse.Params["error"] = "trans error"
+	expectedResponse.Add("error", "trans error")
 	assert.Equal(t, expectedErr, err)
 	assert.Equal(t, expectedResponse, response)
 	factory.AssertExpectations(t)
@@ -81,28 +81,29 @@ func TestExecuteOK(t *testing.T) {
 		{Key: "param 2", Value: "value 2"},
 	}
 
-	responseParams := make(map[string]string)
-	responseParams["status"] = usecases.TransOK
-	responseParams[response1] = response1
+	responseParams := []domain.TransField{
+		{Key: "status", Value: usecases.TransOK},
+		{Key: response1, Value: response1},
+	}
 	command := domain.TransCommand{
 		Command: cmd,
 		Params:  params,
 	}
 
 	handler := MockTransHandler{}
-	handler.On("SendCommand", cmd, params).Return(responseParams, nil).Once()
+	handler.On("SendCommand", mock.Anything, command).Return(responseParams, nil).Once()
 
 	factory := MockTransFactory{}
 	factory.On("MakeTransHandler").Return(&handler).Once()
 
 	repo := NewTransRepo(&factory)
 
-	response, err := repo.Execute(command)
+	response, err := repo.Execute(context.Background(), command)
 	expectedResponse := domain.TransResponse{
 		Status: usecases.TransOK,
 		Params: make(map[string]string),
 	}
-	expectedResponse.Params[response1] = response1
+	expectedResponse.Add(response1, response1)
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResponse, response)
 	factory.AssertExpectations(t)
@@ -111,13 +112,11 @@ func TestExecuteOK(t *testing.T) {
 
 func TestExecuteOKNumbers(t *testing.T) {
 	cmd := command1
-	params := []domain.TransParams{
-		{Key: "param 1", Value: 1980},
-	}
 
-	responseParams := make(map[string]string)
-	responseParams["status"] = usecases.TransOK
-	responseParams[response1] = response1
+	responseParams := []domain.TransField{
+		{Key: "status", Value: usecases.TransOK},
+		{Key: response1, Value: response1},
+	}
 	command := domain.TransCommand{
 		Command: cmd,
 		Params:  make([]domain.TransParams, 0),
@@ -131,21 +130,59 @@ func TestExecuteOKNumbers(t *testing.T) {
 	)
 
 	handler := MockTransHandler{}
-	handler.On("SendCommand", cmd, params).Return(responseParams, nil).Once()
+	handler.On("SendCommand", mock.Anything, command).Return(responseParams, nil).Once()
 
 	factory := MockTransFactory{}
 	factory.On("MakeTransHandler").Return(&handler).Once()
 
 	repo := NewTransRepo(&factory)
 
-	response, err := repo.Execute(command)
+	response, err := repo.Execute(context.Background(), command)
 	expectedResponse := domain.TransResponse{
 		Status: usecases.TransOK,
 		Params: make(map[string]string),
 	}
-	expectedResponse.Params[response1] = response1
+	expectedResponse.Add(response1, response1)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedResponse, response)
+	factory.AssertExpectations(t)
+	handler.AssertExpectations(t)
+}
+
+func TestExecuteOKRepeatedKeys(t *testing.T) {
+	cmd := command1
+	params := []domain.TransParams{}
+
+	responseParams := []domain.TransField{
+		{Key: "status", Value: usecases.TransOK},
+		{Key: "pack_id", Value: "1"},
+		{Key: "pack_id", Value: "2"},
+	}
+	command := domain.TransCommand{
+		Command: cmd,
+		Params:  params,
+	}
+
+	handler := MockTransHandler{}
+	handler.On("SendCommand", mock.Anything, command).Return(responseParams, nil).Once()
+
+	factory := MockTransFactory{}
+	factory.On("MakeTransHandler").Return(&handler).Once()
+
+	repo := NewTransRepo(&factory)
+
+	response, err := repo.Execute(context.Background(), command)
+	expectedResponse := domain.TransResponse{
+		Status: usecases.TransOK,
+		Params: map[string]string{"pack_id": "2"},
+		Fields: []domain.TransField{
+			{Key: "pack_id", Value: "1"},
+			{Key: "pack_id", Value: "2"},
+		},
+	}
 	assert.NoError(t, err)
 	assert.Equal(t, expectedResponse, response)
+	assert.Equal(t, []string{"1", "2"}, response.Values("pack_id"))
 	factory.AssertExpectations(t)
 	handler.AssertExpectations(t)
 }
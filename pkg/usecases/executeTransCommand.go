@@ -1,8 +1,8 @@
 package usecases
 
 import (
+	"context"
 	"fmt"
-	"strings"
 
 	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
 )
@@ -23,7 +23,7 @@ const TransNoCommand = "TRANS_ERROR_NO_SUCH_COMMAND:Err no such command"
 // As a User, I would like to execute my TransCommand on a Trans server and get the corresponding response
 // ExecuteTrans should return a response, or an appropriate error if there was a problem.
 type ExecuteTransUsecase interface {
-	ExecuteCommand(command domain.TransCommand) (domain.TransResponse, error)
+	ExecuteCommand(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error)
 }
 
 // TransInteractorLogger defines all the events a TransInteractor may
@@ -38,10 +38,15 @@ type TransInteractorLogger interface {
 type TransInteractor struct {
 	Logger     TransInteractorLogger
 	Repository domain.TransRepository
+	// Schemas the params declared for each command. Commands without a schema aren't validated
+	Schemas map[string]domain.CommandSchema
 }
 
 // ExecuteCommand executes the given TransCommand and returns the corresponding TransResponse.
+// Params that don't follow the schema of the command are rejected before
+// reaching the repository. The command is abandoned once ctx is done
 func (interactor TransInteractor) ExecuteCommand(
+	ctx context.Context,
 	command domain.TransCommand,
 ) (domain.TransResponse, error) {
 	response := domain.TransResponse{
@@ -53,34 +58,47 @@ func (interactor TransInteractor) ExecuteCommand(
 		interactor.Logger.LogBadInput(command)
 		return response, fmt.Errorf("invalid command %+v", command)
 	}
+	if schema, ok := interactor.Schemas[command.Command]; ok {
+		if errs := validateParams(schema, command.Params); len(errs) > 0 {
+			interactor.Logger.LogBadInput(command)
+			response.Add("error", errs.Error())
+			return response, errs
+		}
+	}
 
 	// Execute the command and retrieve the response
-	response, err := interactor.Repository.Execute(command)
+	response, err := interactor.Repository.Execute(ctx, command)
 	if err != nil {
 		// Report the error
 		interactor.Logger.LogRepositoryError(command, err)
+		switch err.(type) {
+		// rejected params are reported as they are, so the caller can tell which ones failed
+		case domain.ParamsError:
+			response.Status = TransError
+			return response, err
+		// a busy trans is reported as it is, so the caller can try again later,
+		// and failures already classified by the repository keep their class
+		case domain.BusyError, domain.TransError:
+			return response, err
+		}
+		message := "error during execution"
 		if transErr, ok := response.Params["error"]; ok {
-			err = fmt.Errorf(transErr)
-		} else {
-			err = fmt.Errorf("error during execution")
+			message = transErr
 		}
-	}
-	// if the command sent doesn´t exists in the server
-	if response.Status == TransNoCommand {
-		err = fmt.Errorf("error command doesn't exists")
 		response.Status = TransError
-		response.Params["error"] = err.Error()
+		return response, domain.TransError{
+			Class:   domain.ErrorClassBadRequest,
+			Code:    TransError,
+			Message: message,
+		}
 	}
-	// if the error is a database error
-	if strings.Contains(response.Status, TransDatabaseError) {
-		// get the specific error message from the status response
-		errorString := strings.Replace(response.Status, TransDatabaseError, "", 1)
-		errorString = strings.Replace(errorString, ":", "", 1)
-		err = fmt.Errorf(errorString)
-		interactor.Logger.LogRepositoryError(command, err)
-		response.Status = TransDatabaseError
-		response.Params["error"] = err.Error()
+	// trans may answer with an error status or with errors on the params
+	response, transErr := parseTransError(response)
+	if transErr != nil {
+		if transErr.Class == domain.ErrorClassUpstream || transErr.Class == domain.ErrorClassTimeout {
+			interactor.Logger.LogRepositoryError(command, *transErr)
+		}
+		return response, *transErr
 	}
-
-	return response, err
+	return response, nil
 }
@@ -1,9 +1,11 @@
 package usecases
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -14,8 +16,8 @@ type MockTransRepository struct {
 	mock.Mock
 }
 
-func (m *MockTransRepository) Execute(command domain.TransCommand) (domain.TransResponse, error) {
-	ret := m.Called(command)
+func (m *MockTransRepository) Execute(ctx context.Context, command domain.TransCommand) (domain.TransResponse, error) {
+	ret := m.Called(ctx, command)
 	return ret.Get(0).(domain.TransResponse), ret.Error(1)
 }
 
@@ -41,12 +43,33 @@ func TestTransInteractorInvalidCommand(t *testing.T) {
 	command := domain.TransCommand{}
 	logger.On("LogBadInput", command)
 
-	_, err := interactor.ExecuteCommand(command)
+	_, err := interactor.ExecuteCommand(context.Background(), command)
 	assert.Error(t, err)
 	repo.AssertExpectations(t)
 	logger.AssertExpectations(t)
 }
 
+func TestTransInteractorSchema(t *testing.T) {
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+		Schemas: map[string]domain.CommandSchema{
+			"get_account": {Params: map[string]domain.ParamSchema{"account_id": {Required: true}}},
+		},
+	}
+	command := domain.TransCommand{Command: "get_account"}
+	logger.On("LogBadInput", command)
+
+	response, err := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, domain.ParamsError{{Param: "account_id", Reason: "param is required"}}, err)
+	assert.Equal(t, TransError, response.Status)
+	assert.Equal(t, err.Error(), response.Params["error"])
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
 func TestTransInteractorRepositoryError(t *testing.T) {
 	command := domain.TransCommand{
 		Command: "command 1",
@@ -55,16 +78,46 @@ func TestTransInteractorRepositoryError(t *testing.T) {
 	err := errors.New("error")
 	logger := &MockTransInteractorLogger{}
 	repo := &MockTransRepository{}
-	repo.On("Execute", command).Return(response, err).Once()
+	repo.On("Execute", mock.Anything, command).Return(response, err).Once()
 	interactor := TransInteractor{
 		Logger:     logger,
 		Repository: repo,
 	}
 	logger.On("LogRepositoryError", command, err).Once()
-	expectedErr := fmt.Errorf("error during execution")
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	expectedErr := domain.TransError{
+		Class:   domain.ErrorClassBadRequest,
+		Code:    TransError,
+		Message: "error during execution",
+	}
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 	assert.Error(t, returnErr)
 	assert.Equal(t, expectedErr, returnErr)
+	assert.Equal(t, domain.TransResponse{Status: TransError}, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorRepositoryTransError(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command 1",
+	}
+	err := domain.TransError{
+		Class:   domain.ErrorClassTimeout,
+		Code:    "TRANS_TIMEOUT",
+		Message: "trans didn't answer in time",
+	}
+	response := domain.TransResponse{}
+	response.Add("error", err.Error())
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", mock.Anything, command).Return(response, err).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogRepositoryError", command, err).Once()
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, err, returnErr)
 	assert.Equal(t, response, returnResp)
 	repo.AssertExpectations(t)
 	logger.AssertExpectations(t)
@@ -74,7 +127,6 @@ func TestTransInteractorTransNoCommand(t *testing.T) {
 	command := domain.TransCommand{
 		Command: "command 1",
 	}
-	err := errors.New("error command doesn't exists")
 	response := domain.TransResponse{
 		Status: TransNoCommand,
 		Params: make(map[string]string),
@@ -82,19 +134,22 @@ func TestTransInteractorTransNoCommand(t *testing.T) {
 
 	logger := &MockTransInteractorLogger{}
 	repo := &MockTransRepository{}
-	repo.On("Execute", command).Return(response, err).Once()
+	repo.On("Execute", mock.Anything, command).Return(response, nil).Once()
 	interactor := TransInteractor{
 		Logger:     logger,
 		Repository: repo,
 	}
-	logger.On("LogRepositoryError", command, err).Once()
-	expectedErr := fmt.Errorf("error command doesn't exists")
+	expectedErr := domain.TransError{
+		Class:   domain.ErrorClassNotFound,
+		Code:    "TRANS_ERROR_NO_SUCH_COMMAND",
+		Message: "Err no such command",
+	}
 	expectedResponse := domain.TransResponse{
-		Status: TransError,
+		Status: "TRANS_ERROR_NO_SUCH_COMMAND",
 		Params: make(map[string]string),
 	}
-	expectedResponse.Params["error"] = expectedErr.Error()
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	expectedResponse.Add("error", "Err no such command")
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 	assert.Error(t, returnErr)
 	assert.Equal(t, expectedErr, returnErr)
 	assert.Equal(t, expectedResponse, returnResp)
@@ -107,30 +162,31 @@ func TestTransInteractorTransDatabaseError(t *testing.T) {
 		Command: "command 1",
 	}
 	errorStringDB := "ERROR EXECUTING QUERY"
-	errorString := "Trans Database error"
-	err := errors.New(errorString)
-	errDB := errors.New(errorStringDB)
 	response := domain.TransResponse{
 		Status: fmt.Sprintf("%s:%s", TransDatabaseError, errorStringDB),
 		Params: make(map[string]string),
 	}
+	errDB := domain.TransError{
+		Class:   domain.ErrorClassUpstream,
+		Code:    TransDatabaseError,
+		Message: errorStringDB,
+	}
 
 	logger := &MockTransInteractorLogger{}
 	repo := &MockTransRepository{}
-	repo.On("Execute", command).Return(response, err).Once()
+	repo.On("Execute", mock.Anything, command).Return(response, nil).Once()
 	interactor := TransInteractor{
 		Logger:     logger,
 		Repository: repo,
 	}
-	logger.On("LogRepositoryError", command, err).Once()
 	logger.On("LogRepositoryError", command, errDB).Once()
 
 	expectedResponse := domain.TransResponse{
 		Status: TransDatabaseError,
 		Params: make(map[string]string),
 	}
-	expectedResponse.Params["error"] = errorStringDB
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	expectedResponse.Add("error", errorStringDB)
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 
 	assert.Error(t, returnErr)
 	assert.Equal(t, errDB, returnErr)
@@ -139,6 +195,41 @@ func TestTransInteractorTransDatabaseError(t *testing.T) {
 	logger.AssertExpectations(t)
 }
 
+func TestTransInteractorFieldErrors(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "create_account",
+	}
+	response := domain.TransResponse{Status: TransError}
+	response.Add("name", "edgar")
+	response.Add("email", "ERROR_EMAIL_INVALID")
+	response.Add("phone", "ERROR_PHONE_TOO_SHORT")
+
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", mock.Anything, command).Return(response, nil).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	expectedErr := domain.TransError{
+		Class: domain.ErrorClassValidation,
+		Code:  TransError,
+		Fields: []domain.FieldError{
+			{Param: "email", Code: "ERROR_EMAIL_INVALID"},
+			{Param: "phone", Code: "ERROR_PHONE_TOO_SHORT"},
+		},
+	}
+	expectedResponse := domain.TransResponse{Status: TransError}
+	expectedResponse.Add("name", "edgar")
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+
+	assert.Equal(t, expectedErr, returnErr)
+	assert.EqualError(t, returnErr, "TRANS_ERROR - email: ERROR_EMAIL_INVALID, phone: ERROR_PHONE_TOO_SHORT")
+	assert.Equal(t, expectedResponse, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
 func TestTransInteractorExecuteCommandOK(t *testing.T) {
 	command := domain.TransCommand{
 		Command: "command 1",
@@ -148,14 +239,64 @@ func TestTransInteractorExecuteCommandOK(t *testing.T) {
 	}
 	logger := &MockTransInteractorLogger{}
 	repo := &MockTransRepository{}
-	repo.On("Execute", command).Return(response, nil).Once()
+	repo.On("Execute", mock.Anything, command).Return(response, nil).Once()
 	interactor := TransInteractor{
 		Logger:     logger,
 		Repository: repo,
 	}
-	returnResp, returnErr := interactor.ExecuteCommand(command)
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
 	assert.NoError(t, returnErr)
 	assert.Equal(t, response, returnResp)
 	repo.AssertExpectations(t)
 	logger.AssertExpectations(t)
 }
+
+func TestTransInteractorParamsError(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command 1",
+	}
+	err := domain.ParamsError{{Param: "tags", Reason: "unsupported value type []interface {}"}}
+	response := domain.TransResponse{}
+	response.Add("error", err.Error())
+
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", mock.Anything, command).Return(response, err).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogRepositoryError", command, err).Once()
+
+	expectedResponse := response
+	expectedResponse.Status = TransError
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, err, returnErr)
+	assert.Equal(t, expectedResponse, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
+
+func TestTransInteractorBusyError(t *testing.T) {
+	command := domain.TransCommand{
+		Command: "command 1",
+	}
+	err := domain.BusyError{RetryAfter: time.Second}
+	response := domain.TransResponse{}
+	response.Add("error", err.Error())
+
+	logger := &MockTransInteractorLogger{}
+	repo := &MockTransRepository{}
+	repo.On("Execute", mock.Anything, command).Return(response, err).Once()
+	interactor := TransInteractor{
+		Logger:     logger,
+		Repository: repo,
+	}
+	logger.On("LogRepositoryError", command, err).Once()
+
+	returnResp, returnErr := interactor.ExecuteCommand(context.Background(), command)
+	assert.Equal(t, err, returnErr)
+	assert.Equal(t, response, returnResp)
+	repo.AssertExpectations(t)
+	logger.AssertExpectations(t)
+}
@@ -0,0 +1,97 @@
+package usecases
+
+import (
+	"strings"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// fieldErrorPrefix prefix of the values trans returns on params with errors,
+// like email:ERROR_EMAIL_INVALID
+const fieldErrorPrefix = "ERROR_"
+
+// errorClassRule assigns a class to the trans codes that contain marker
+type errorClassRule struct {
+	marker string
+	class  domain.ErrorClass
+}
+
+// errorClassRules are checked in order and the first matching rule wins.
+// Database errors about duplicated keys are conflicts, so they go first
+var errorClassRules = []errorClassRule{ // nolint: gochecknoglobals
+	{marker: "NO_SUCH", class: domain.ErrorClassNotFound},
+	{marker: "NOT_FOUND", class: domain.ErrorClassNotFound},
+	{marker: "ALREADY_EXISTS", class: domain.ErrorClassConflict},
+	{marker: "DUPLICATE", class: domain.ErrorClassConflict},
+	{marker: "TIMEOUT", class: domain.ErrorClassTimeout},
+	{marker: TransDatabaseError, class: domain.ErrorClassUpstream},
+}
+
+// classifyCode returns the class of a trans code, if any rule matches it
+func classifyCode(code string) (domain.ErrorClass, bool) {
+	code = strings.ToUpper(code)
+	for _, rule := range errorClassRules {
+		if strings.Contains(code, rule.marker) {
+			return rule.class, true
+		}
+	}
+	return "", false
+}
+
+// classifyError returns the class of an error code. Field errors are
+// validation errors, unless their codes tell otherwise
+func classifyError(status string, fields []domain.FieldError) domain.ErrorClass {
+	if len(fields) > 0 {
+		for _, field := range fields {
+			if class, ok := classifyCode(field.Code); ok {
+				return class
+			}
+		}
+		return domain.ErrorClassValidation
+	}
+	if class, ok := classifyCode(status); ok {
+		return class
+	}
+	return domain.ErrorClassBadRequest
+}
+
+// parseTransError looks for errors on a trans response: any status other
+// than TRANS_OK, given as CODE or CODE:message, and the params whose value is
+// an ERROR_* code. Those params are taken out of the returned response and
+// reported as field errors. The message, if any, is kept as the error param
+func parseTransError(response domain.TransResponse) (domain.TransResponse, *domain.TransError) {
+	if response.Status == "" || response.Status == TransOK {
+		return response, nil
+	}
+	parsed := domain.TransResponse{
+		Params: make(map[string]string),
+	}
+	var fields []domain.FieldError
+	for _, field := range response.Fields {
+		if !field.Blob && strings.HasPrefix(field.Value, fieldErrorPrefix) {
+			fields = append(fields, domain.FieldError{Param: field.Key, Code: field.Value})
+			continue
+		}
+		parsed.AddField(field)
+	}
+	// responses built without fields only have params
+	if len(response.Fields) == 0 {
+		for key, value := range response.Params {
+			parsed.Params[key] = value
+		}
+	}
+	code, message := response.Status, ""
+	if i := strings.Index(code, ":"); i >= 0 {
+		code, message = code[:i], code[i+1:]
+	}
+	parsed.Status = code
+	if _, ok := parsed.Params["error"]; !ok && message != "" {
+		parsed.Add("error", message)
+	}
+	return parsed, &domain.TransError{
+		Class:   classifyError(code, fields),
+		Code:    code,
+		Message: parsed.Params["error"],
+		Fields:  fields,
+	}
+}
@@ -0,0 +1,80 @@
+package usecases
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestClassifyError(t *testing.T) {
+	cases := map[string]struct {
+		status   string
+		fields   []domain.FieldError
+		expected domain.ErrorClass
+	}{
+		"trans error":     {status: TransError, expected: domain.ErrorClassBadRequest},
+		"no such command": {status: TransNoCommand, expected: domain.ErrorClassNotFound},
+		"database error": {
+			status:   "TRANS_DATABASE_ERROR:ERROR EXECUTING QUERY",
+			expected: domain.ErrorClassUpstream,
+		},
+		"duplicated key": {
+			status:   "TRANS_DATABASE_ERROR:duplicate key value",
+			expected: domain.ErrorClassConflict,
+		},
+		"timeout": {status: "TRANS_TIMEOUT", expected: domain.ErrorClassTimeout},
+		"field errors": {
+			status:   TransError,
+			fields:   []domain.FieldError{{Param: "email", Code: "ERROR_EMAIL_INVALID"}},
+			expected: domain.ErrorClassValidation,
+		},
+		"field conflict": {
+			status:   TransError,
+			fields:   []domain.FieldError{{Param: "email", Code: "ERROR_ACCOUNT_ALREADY_EXISTS"}},
+			expected: domain.ErrorClassConflict,
+		},
+		"field not found": {
+			status:   TransError,
+			fields:   []domain.FieldError{{Param: "ad_id", Code: "ERROR_AD_NOT_FOUND"}},
+			expected: domain.ErrorClassNotFound,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, c.expected, classifyError(c.status, c.fields))
+		})
+	}
+}
+
+func TestParseTransErrorOK(t *testing.T) {
+	response := domain.TransResponse{Status: TransOK}
+	response.Add("error_count", "ERROR_NONE")
+
+	parsed, err := parseTransError(response)
+	assert.Nil(t, err)
+	assert.Equal(t, response, parsed)
+}
+
+func TestParseTransErrorKeepsErrorParam(t *testing.T) {
+	response := domain.TransResponse{Status: TransError}
+	response.Add("error", "ad is already bumped")
+
+	parsed, err := parseTransError(response)
+	assert.Equal(t, &domain.TransError{
+		Class:   domain.ErrorClassBadRequest,
+		Code:    TransError,
+		Message: "ad is already bumped",
+	}, err)
+	assert.Equal(t, response, parsed)
+}
+
+func TestParseTransErrorClassifiesCodeOnly(t *testing.T) {
+	// markers on the message don't change the class
+	for _, status := range []string{"TRANS_ERROR:timeout while saving", "TRANS_ERROR:user not found"} {
+		_, err := parseTransError(domain.TransResponse{Status: status})
+		assert.Equal(t, domain.ErrorClassBadRequest, err.Class, status)
+	}
+	_, err := parseTransError(domain.TransResponse{Status: "TRANS_TIMEOUT:took too long"})
+	assert.Equal(t, domain.ErrorClassTimeout, err.Class)
+}
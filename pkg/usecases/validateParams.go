@@ -0,0 +1,103 @@
+package usecases
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+// validateParams checks the params against the schema of their command,
+// returning every param that doesn't follow it, sorted by param
+func validateParams(schema domain.CommandSchema, params []domain.TransParams) domain.ParamsError {
+	var errs domain.ParamsError
+	present := make(map[string]bool)
+	for _, param := range params {
+		paramSchema, ok := schema.Params[param.Key]
+		if !ok {
+			if schema.Strict {
+				errs = append(errs, domain.ParamError{Param: param.Key, Reason: "param is not declared"})
+			}
+			continue
+		}
+		if param.Value == nil {
+			continue
+		}
+		present[param.Key] = true
+		if reason := validateParam(paramSchema, param); reason != "" {
+			errs = append(errs, domain.ParamError{Param: param.Key, Reason: reason})
+		}
+	}
+	for key, paramSchema := range schema.Params {
+		if paramSchema.Required && !present[key] {
+			errs = append(errs, domain.ParamError{Param: key, Reason: "param is required"})
+		}
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Param < errs[j].Param })
+	return errs
+}
+
+// validateParam returns why the value of the param doesn't follow its schema, if it doesn't
+func validateParam(schema domain.ParamSchema, param domain.TransParams) string {
+	if schema.Blob != param.Blob {
+		if schema.Blob {
+			return "value must be a blob"
+		}
+		return "value must not be a blob"
+	}
+	value, reason := paramValue(schema.Type, param.Value)
+	if reason != "" {
+		return reason
+	}
+	if schema.MaxLength > 0 && utf8.RuneCountInString(value) > schema.MaxLength {
+		return fmt.Sprintf("value is longer than %d characters", schema.MaxLength)
+	}
+	if schema.Pattern != nil && !schema.Pattern.MatchString(value) {
+		return "value does not match the pattern"
+	}
+	if len(schema.Enum) > 0 && !contains(schema.Enum, value) {
+		return fmt.Sprintf("value must be one of %s", strings.Join(schema.Enum, ", "))
+	}
+	return ""
+}
+
+// paramValue returns the value as text, or why it isn't of the given type
+func paramValue(paramType domain.ParamType, value interface{}) (string, string) {
+	switch v := value.(type) {
+	case string:
+		if paramType == domain.ParamTypeAny || paramType == domain.ParamTypeString {
+			return v, ""
+		}
+	case bool:
+		if paramType == domain.ParamTypeAny || paramType == domain.ParamTypeBoolean {
+			return strconv.FormatBool(v), ""
+		}
+	case int:
+		return paramValue(paramType, float64(v))
+	case int64:
+		return paramValue(paramType, float64(v))
+	case float64:
+		integer := v == math.Trunc(v) && !math.IsInf(v, 0)
+		if paramType == domain.ParamTypeAny || paramType == domain.ParamTypeNumber ||
+			(paramType == domain.ParamTypeInteger && integer) {
+			return strconv.FormatFloat(v, 'f', -1, 64), ""
+		}
+	default:
+		return "", fmt.Sprintf("unsupported value type %T", value)
+	}
+	return "", fmt.Sprintf("value must be of type %s", paramType)
+}
+
+// contains checks if values has value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
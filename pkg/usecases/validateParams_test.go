@@ -0,0 +1,71 @@
+package usecases
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.com/yapo_team/legacy/commons/trans/pkg/domain"
+)
+
+func TestValidateParams(t *testing.T) {
+	schema := domain.CommandSchema{
+		Params: map[string]domain.ParamSchema{
+			"subject":  {Type: domain.ParamTypeString, Required: true, MaxLength: 5},
+			"price":    {Type: domain.ParamTypeInteger},
+			"weight":   {Type: domain.ParamTypeNumber},
+			"company":  {Type: domain.ParamTypeBoolean},
+			"email":    {Pattern: regexp.MustCompile("^(?:[a-z]+@yapo.cl)$")},
+			"category": {Enum: []string{"1020", "2020"}},
+			"image":    {Blob: true},
+		},
+	}
+	valid := []domain.TransParams{
+		{Key: "subject", Value: "sofá"},
+		{Key: "price", Value: float64(1000)},
+		{Key: "weight", Value: 1.5},
+		{Key: "company", Value: true},
+		{Key: "email", Value: "ana@yapo.cl"},
+		{Key: "category", Value: 1020},
+		{Key: "image", Value: "aGVsbG8=", Blob: true},
+		{Key: "extra", Value: "not strict"},
+		{Key: "weight", Value: nil},
+	}
+	assert.Empty(t, validateParams(schema, valid))
+
+	invalid := []domain.TransParams{
+		{Key: "subject", Value: "too long"},
+		{Key: "price", Value: 10.5},
+		{Key: "weight", Value: "heavy"},
+		{Key: "company", Value: "yes"},
+		{Key: "email", Value: "ana@gmail.com"},
+		{Key: "category", Value: "3020"},
+		{Key: "image", Value: "aGVsbG8="},
+		{Key: "subject", Value: []interface{}{"a"}},
+	}
+	assert.Equal(t, domain.ParamsError{
+		{Param: "category", Reason: "value must be one of 1020, 2020"},
+		{Param: "company", Reason: "value must be of type boolean"},
+		{Param: "email", Reason: "value does not match the pattern"},
+		{Param: "image", Reason: "value must be a blob"},
+		{Param: "price", Reason: "value must be of type integer"},
+		{Param: "subject", Reason: "value is longer than 5 characters"},
+		{Param: "subject", Reason: "unsupported value type []interface {}"},
+		{Param: "weight", Reason: "value must be of type number"},
+	}, validateParams(schema, invalid))
+}
+
+func TestValidateParamsRequiredAndStrict(t *testing.T) {
+	schema := domain.CommandSchema{
+		Params: map[string]domain.ParamSchema{"ad_id": {Required: true}},
+		Strict: true,
+	}
+	params := []domain.TransParams{
+		{Key: "ad_id", Value: nil},
+		{Key: "adid", Value: 1},
+	}
+	assert.Equal(t, domain.ParamsError{
+		{Param: "ad_id", Reason: "param is required"},
+		{Param: "adid", Reason: "param is not declared"},
+	}, validateParams(schema, params))
+}